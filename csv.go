@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/scrapinghub/shubc/scrapinghub"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Write the items as CSV (or TSV) flattening their nested values, with a
+// row of headers. If the columns are not given (-fields) the rows are
+// spooled to a temporary file to discover the columns of all the items
+// before writing them.
+type csv_writer struct {
+	out       io.Writer
+	file      io.WriteCloser
+	buf       *bufio.Writer
+	opts      scrapinghub.FlattenOptions
+	delimiter string
+	quote     string
+	headers   bool
+	columns   []string
+	known     map[string]bool
+	spool     *os.File
+	spool_buf *bufio.Writer
+	spool_enc *json.Encoder
+	started   bool
+}
+
+func new_csv_writer(flags *PFlags) (*csv_writer, error) {
+	cw := &csv_writer{
+		opts:  scrapinghub.FlattenOptions{Separator: flags.Separator, ListSeparator: flags.ListSeparator},
+		quote: flags.Quote, headers: true, known: make(map[string]bool),
+	}
+	switch flags.Lists {
+	case "", "join":
+	case "explode":
+		cw.opts.ExplodeLists = true
+	default:
+		return nil, fmt.Errorf("csv: wrong value for -lists: %s, use join or explode", flags.Lists)
+	}
+	switch cw.quote {
+	case "":
+		cw.quote = "minimal"
+	case "minimal", "all", "none":
+	default:
+		return nil, fmt.Errorf("csv: wrong value for -quote: %s, use minimal, all or none", flags.Quote)
+	}
+	cw.delimiter = flags.Delimiter
+	if cw.delimiter == "" {
+		cw.delimiter = ","
+		if flags.Format == "tsv" {
+			cw.delimiter = "\t"
+		}
+	}
+	cw.delimiter = strings.Replace(cw.delimiter, `\t`, "\t", -1)
+
+	if flags.CSVFlags.Fields != "" {
+		cw.columns = strings.Split(flags.CSVFlags.Fields, ",")
+	} else {
+		spool, err := ioutil.TempFile("", "shubc-csv")
+		if err != nil {
+			return nil, fmt.Errorf("csv: %s", err)
+		}
+		cw.spool = spool
+		cw.spool_buf = bufio.NewWriter(spool)
+		cw.spool_enc = json.NewEncoder(cw.spool_buf)
+	}
+
+	cw.out = os.Stdout
+	if flags.Output != "" {
+		file, err := open_output_file(flags, flags.Output, flags.Compress, true)
+		if err != nil {
+			return nil, fmt.Errorf("csv: %s", err)
+		}
+		cw.file = file
+		cw.out = file
+	}
+	cw.buf = bufio.NewWriter(cw.out)
+	return cw, nil
+}
+
+func (cw *csv_writer) field(value string) string {
+	needs_quotes := false
+	switch cw.quote {
+	case "all":
+		needs_quotes = true
+	case "minimal":
+		needs_quotes = value != "" && (strings.Contains(value, cw.delimiter) ||
+			strings.ContainsAny(value, "\"\r\n") || value[0] == ' ' || value[len(value)-1] == ' ')
+	}
+	if !needs_quotes {
+		return value
+	}
+	return `"` + strings.Replace(value, `"`, `""`, -1) + `"`
+}
+
+func (cw *csv_writer) writeRow(values []string) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = cw.field(v)
+	}
+	_, err := cw.buf.WriteString(strings.Join(fields, cw.delimiter) + "\n")
+	return err
+}
+
+func (cw *csv_writer) writeRecord(row map[string]string) error {
+	if !cw.started {
+		cw.started = true
+		if cw.headers {
+			if err := cw.writeRow(cw.columns); err != nil {
+				return err
+			}
+		}
+	}
+	values := make([]string, len(cw.columns))
+	for i, c := range cw.columns {
+		values[i] = row[c]
+	}
+	return cw.writeRow(values)
+}
+
+func (cw *csv_writer) Write(job_id string, item map[string]interface{}) error {
+	rows, err := scrapinghub.FlattenItem(item, &cw.opts)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if cw.spool == nil {
+			if err := cw.writeRecord(row); err != nil {
+				return fmt.Errorf("csv: %s", err)
+			}
+			continue
+		}
+		// The columns are in order of appearance (and sorted in every row)
+		keys := make([]string, 0, len(row))
+		for k := range row {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !cw.known[k] {
+				cw.known[k] = true
+				cw.columns = append(cw.columns, k)
+			}
+		}
+		if err := cw.spool_enc.Encode(row); err != nil {
+			return fmt.Errorf("csv: %s", err)
+		}
+	}
+	return nil
+}
+
+// Write the rows spooled, once all the columns are known
+func (cw *csv_writer) writeSpool() error {
+	defer os.Remove(cw.spool.Name())
+	defer cw.spool.Close()
+	if err := cw.spool_buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := cw.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(cw.spool))
+	for {
+		var row map[string]string
+		err := dec.Decode(&row)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := cw.writeRecord(row); err != nil {
+			return err
+		}
+	}
+}
+
+func (cw *csv_writer) Close() error {
+	var err error
+	if cw.spool != nil {
+		err = cw.writeSpool()
+	}
+	if err == nil {
+		err = cw.buf.Flush()
+	}
+	if cw.file != nil {
+		if cerr := cw.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("csv: %s", err)
+	}
+	return nil
+}
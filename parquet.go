@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Number of items used to infer the schema of the Parquet file
+const parquet_sample_size = 1000
+
+var re_parquet_name = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Node of the schema inferred from the items: a value of kind bool, int,
+// double or string, a struct with `fields` or a list of `elem`
+type parquet_field struct {
+	name   string
+	key    string
+	kind   string
+	fields []*parquet_field
+	elem   *parquet_field
+}
+
+func (pf *parquet_field) field(key string) *parquet_field {
+	for _, f := range pf.fields {
+		if f.key == key {
+			return f
+		}
+	}
+	f := &parquet_field{key: key}
+	pf.fields = append(pf.fields, f)
+	return f
+}
+
+// Merge the type of the value `v` into the node. Incompatible types end as
+// string (objects and lists are stored as JSON).
+func (pf *parquet_field) merge(v interface{}) {
+	kind := ""
+	switch val := v.(type) {
+	case nil:
+		return
+	case bool:
+		kind = "bool"
+	case json.Number:
+		kind = "double"
+		if _, err := val.Int64(); err == nil {
+			kind = "int"
+		}
+	case string:
+		kind = "string"
+	case map[string]interface{}:
+		kind = "struct"
+	case []interface{}:
+		kind = "list"
+	}
+	switch {
+	case pf.kind == "" || pf.kind == kind:
+		pf.kind = kind
+	case pf.kind == "int" && kind == "double", pf.kind == "double" && kind == "int":
+		pf.kind = "double"
+	default:
+		pf.kind = "string"
+		pf.fields = nil
+		pf.elem = nil
+	}
+	switch pf.kind {
+	case "struct":
+		for k, fv := range v.(map[string]interface{}) {
+			pf.field(k).merge(fv)
+		}
+	case "list":
+		if pf.elem == nil {
+			pf.elem = &parquet_field{key: "element"}
+		}
+		for _, ev := range v.([]interface{}) {
+			pf.elem.merge(ev)
+		}
+	}
+}
+
+// Fix the schema once inferred: fields with unknown type (always null) or
+// empty structs are strings, and the names are valid and unique column names.
+// The names `reserved` for other columns of the struct are renamed with a
+// "_" prefix (e.g: a field job_id is the column _job_id).
+func (pf *parquet_field) finish(reserved ...string) {
+	if pf.kind == "" || (pf.kind == "struct" && len(pf.fields) == 0) {
+		pf.kind = "string"
+		pf.fields = nil
+	}
+	if pf.kind == "list" {
+		pf.elem.name = "element"
+		pf.elem.finish()
+	}
+	sort.Slice(pf.fields, func(i, j int) bool { return pf.fields[i].key < pf.fields[j].key })
+	used := make(map[string]bool)
+	for _, name := range reserved {
+		used[name] = true
+	}
+	for _, f := range pf.fields {
+		name := re_parquet_name.ReplaceAllString(f.key, "_")
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+		for _, r := range reserved {
+			if strings.ToLower(name) == r {
+				name = "_" + name
+			}
+		}
+		// The column names are case insensitive in some readers
+		for used[strings.ToLower(name)] {
+			name += "_"
+		}
+		used[strings.ToLower(name)] = true
+		f.name = name
+		f.finish()
+	}
+}
+
+func (pf *parquet_field) jsonSchema() map[string]interface{} {
+	node := map[string]interface{}{}
+	tag := "name=" + pf.name + ", repetitiontype=OPTIONAL"
+	switch pf.kind {
+	case "bool":
+		tag += ", type=BOOLEAN"
+	case "int":
+		tag += ", type=INT64"
+	case "double":
+		tag += ", type=DOUBLE"
+	case "string":
+		tag += ", type=BYTE_ARRAY, convertedtype=UTF8"
+	case "list":
+		tag += ", type=LIST"
+		node["Fields"] = []interface{}{pf.elem.jsonSchema()}
+	case "struct":
+		var fields []interface{}
+		for _, f := range pf.fields {
+			fields = append(fields, f.jsonSchema())
+		}
+		node["Fields"] = fields
+	}
+	node["Tag"] = tag
+	return node
+}
+
+// Returns the value `v` converted to the type of the node, nil if it can't
+// be converted. `dropped` counts the values dropped.
+func (pf *parquet_field) coerce(v interface{}, dropped *int) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch pf.kind {
+	case "bool":
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	case "int":
+		if n, ok := v.(json.Number); ok {
+			if _, err := n.Int64(); err == nil {
+				return n
+			}
+		}
+	case "double":
+		if n, ok := v.(json.Number); ok {
+			return n
+		}
+	case "string":
+		switch val := v.(type) {
+		case string:
+			return val
+		case json.Number:
+			return val.String()
+		}
+		b, _ := json.Marshal(v)
+		return string(b)
+	case "struct":
+		if m, ok := v.(map[string]interface{}); ok {
+			out := make(map[string]interface{}, len(pf.fields))
+			for _, f := range pf.fields {
+				out[f.name] = f.coerce(m[f.key], dropped)
+			}
+			for k := range m {
+				if !pf.has(k) {
+					*dropped++
+				}
+			}
+			return out
+		}
+	case "list":
+		if l, ok := v.([]interface{}); ok {
+			out := make([]interface{}, len(l))
+			for i, ev := range l {
+				out[i] = pf.elem.coerce(ev, dropped)
+			}
+			return out
+		}
+	}
+	*dropped++
+	return nil
+}
+
+func (pf *parquet_field) has(key string) bool {
+	for _, f := range pf.fields {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the items to a Parquet file, with the schema inferred from the
+// first items (all the columns are nullable, objects are structs and lists
+// are lists) plus the column job_id. Values which don't match the schema
+// are written as null.
+type parquet_writer struct {
+	file    io.WriteCloser
+	pw      *writer.JSONWriter
+	codec   parquet.CompressionCodec
+	root    *parquet_field
+	buffer  []job_item
+	dropped int
+	ready   bool
+}
+
+func new_parquet_writer(flags *PFlags) (*parquet_writer, error) {
+	codecs := map[string]parquet.CompressionCodec{
+		"":       parquet.CompressionCodec_SNAPPY,
+		"snappy": parquet.CompressionCodec_SNAPPY,
+		"zstd":   parquet.CompressionCodec_ZSTD,
+		"gzip":   parquet.CompressionCodec_GZIP,
+		"none":   parquet.CompressionCodec_UNCOMPRESSED,
+	}
+	codec, ok := codecs[flags.Compress]
+	if !ok {
+		return nil, fmt.Errorf("parquet: unknown compression %s, use snappy, zstd, gzip or none", flags.Compress)
+	}
+	// The compression is the codec of the file, not of the whole output
+	file, err := open_output_file(flags, flags.Output, "none", false)
+	if err != nil {
+		return nil, fmt.Errorf("parquet: %s", err)
+	}
+	return &parquet_writer{file: file, codec: codec, root: &parquet_field{kind: "struct"}}, nil
+}
+
+func (pqw *parquet_writer) init() error {
+	for _, row := range pqw.buffer {
+		pqw.root.merge(row.item)
+	}
+	// The column job_id is added by the writer
+	pqw.root.finish("job_id")
+	// Without items the root would be a string
+	pqw.root.kind = "struct"
+
+	fields := []interface{}{map[string]interface{}{
+		"Tag": "name=job_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"}}
+	for _, f := range pqw.root.fields {
+		fields = append(fields, f.jsonSchema())
+	}
+	schema, err := json.Marshal(map[string]interface{}{
+		"Tag":    "name=parquet_go_root, repetitiontype=REQUIRED",
+		"Fields": fields,
+	})
+	if err != nil {
+		return err
+	}
+	pw, err := writer.NewJSONWriterFromWriter(string(schema), pqw.file, 4)
+	if err != nil {
+		return fmt.Errorf("parquet: %s", err)
+	}
+	pw.CompressionType = pqw.codec
+	pqw.pw = pw
+	pqw.ready = true
+
+	for _, row := range pqw.buffer {
+		if err := pqw.write(row.job_id, row.item); err != nil {
+			return err
+		}
+	}
+	pqw.buffer = nil
+	return nil
+}
+
+func (pqw *parquet_writer) write(job_id string, item map[string]interface{}) error {
+	row := pqw.root.coerce(item, &pqw.dropped).(map[string]interface{})
+	row["job_id"] = job_id
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if err := pqw.pw.Write(string(b)); err != nil {
+		return fmt.Errorf("parquet: %s", err)
+	}
+	return nil
+}
+
+func (pqw *parquet_writer) Write(job_id string, item map[string]interface{}) error {
+	if !pqw.ready {
+		pqw.buffer = append(pqw.buffer, job_item{job_id, item})
+		if len(pqw.buffer) < parquet_sample_size {
+			return nil
+		}
+		return pqw.init()
+	}
+	return pqw.write(job_id, item)
+}
+
+func (pqw *parquet_writer) Close() error {
+	if !pqw.ready {
+		if err := pqw.init(); err != nil {
+			pqw.file.Close()
+			return err
+		}
+	}
+	if err := pqw.pw.WriteStop(); err != nil {
+		pqw.file.Close()
+		return fmt.Errorf("parquet: %s", err)
+	}
+	if pqw.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "parquet: %d values not matching the schema were written as null\n", pqw.dropped)
+	}
+	return pqw.file.Close()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+)
+
+// Tabular data which can be rendered in any of the output formats
+type table struct {
+	Title   string
+	Headers []string
+	Rows    [][]string
+}
+
+// Render the tables in the format given by -format: table (default),
+// markdown or html. With json format `data` is rendered instead.
+func render_tables(flags *PFlags, tables []table, data interface{}) {
+	switch flags.Format {
+	case "", "table":
+		for _, t := range tables {
+			render_text_table(flags, &t)
+		}
+	case "json":
+		content, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			log.Fatalf("Error rendering JSON: %s\n", err)
+		}
+		print_out(flags, "%s", content)
+	case "markdown", "md":
+		for _, t := range tables {
+			render_markdown_table(flags, &t)
+		}
+	case "html":
+		print_out(flags, "<html><body>")
+		for _, t := range tables {
+			render_html_table(flags, &t)
+		}
+		print_out(flags, "</body></html>")
+	default:
+		log.Fatalf("Unknown format '%s', use table, json, markdown or html\n", flags.Format)
+	}
+}
+
+func render_text_table(flags *PFlags, t *table) {
+	widths := make([]int, len(t.Headers))
+	for i, h := range t.Headers {
+		widths[i] = len(h)
+	}
+	for _, row := range t.Rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	total := 1
+	var cols []string
+	for _, w := range widths {
+		cols = append(cols, fmt.Sprintf("%%%ds", w))
+		total += w + 3
+	}
+	outfmt := "| " + strings.Join(cols, " | ") + " |\n"
+	cells := func(row []string) []interface{} {
+		args := make([]interface{}, len(row))
+		for i, c := range row {
+			args[i] = c
+		}
+		return args
+	}
+
+	if t.Title != "" {
+		print_out(flags, "%s", t.Title)
+	}
+	print_out(flags, "%s", dashes(total))
+	print_out(flags, outfmt, cells(t.Headers)...)
+	print_out(flags, "%s", dashes(total))
+	for _, row := range t.Rows {
+		print_out(flags, outfmt, cells(row)...)
+	}
+	print_out(flags, "%s", dashes(total))
+	print_out(flags, "")
+}
+
+func render_markdown_table(flags *PFlags, t *table) {
+	escape := func(s string) string {
+		return strings.Replace(s, "|", "\\|", -1)
+	}
+	row_line := func(row []string) string {
+		escaped := make([]string, len(row))
+		for i, c := range row {
+			escaped[i] = escape(c)
+		}
+		return "| " + strings.Join(escaped, " | ") + " |"
+	}
+	if t.Title != "" {
+		print_out(flags, "### %s\n", t.Title)
+	}
+	print_out(flags, "%s", row_line(t.Headers))
+	seps := make([]string, len(t.Headers))
+	for i := range seps {
+		seps[i] = "---"
+	}
+	print_out(flags, "|%s|", strings.Join(seps, "|"))
+	for _, row := range t.Rows {
+		print_out(flags, "%s", row_line(row))
+	}
+	print_out(flags, "")
+}
+
+func render_html_table(flags *PFlags, t *table) {
+	if t.Title != "" {
+		print_out(flags, "<h3>%s</h3>", html.EscapeString(t.Title))
+	}
+	print_out(flags, "<table>")
+	var cells []string
+	for _, h := range t.Headers {
+		cells = append(cells, "<th>"+html.EscapeString(h)+"</th>")
+	}
+	print_out(flags, "<tr>%s</tr>", strings.Join(cells, ""))
+	for _, row := range t.Rows {
+		cells = cells[:0]
+		for _, c := range row {
+			cells = append(cells, "<td>"+html.EscapeString(c)+"</td>")
+		}
+		print_out(flags, "<tr>%s</tr>", strings.Join(cells, ""))
+	}
+	print_out(flags, "</table>")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Size of the parts of the multipart uploads, the memory used to upload
+const s3_part_size = 16 * 1024 * 1024
+
+// Upload the data written to an object of a S3 compatible storage while
+// it's written (multipart upload of unknown size, streamed through a pipe).
+// The object is complete when the writer is closed.
+type s3_writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// Returns the client of the S3 endpoint (default s3.amazonaws.com, or the
+// one in -s3_endpoint or $S3_ENDPOINT, e.g: http://localhost:9000 for MinIO),
+// with the credentials from the environment ($AWS_ACCESS_KEY_ID and
+// $AWS_SECRET_ACCESS_KEY, or $MINIO_ACCESS_KEY and $MINIO_SECRET_KEY) or
+// ~/.aws/credentials
+func s3_client(endpoint string) (*minio.Client, error) {
+	if endpoint == "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	secure := true
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("wrong endpoint %s: %s", endpoint, err)
+		}
+		secure = u.Scheme == "https"
+		endpoint = u.Host
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+	})
+	return minio.New(endpoint, &minio.Options{Creds: creds, Secure: secure, Region: os.Getenv("AWS_REGION")})
+}
+
+// Open the object of the destination s3://<bucket>/<key> to write it
+func open_s3_output(dest, endpoint string) (*s3_writer, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("s3: wrong destination %s: %s", dest, err)
+	}
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("s3: wrong destination %s, use s3://<bucket>/<key>", dest)
+	}
+	client, err := s3_client(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %s", err)
+	}
+
+	pr, pw := io.Pipe()
+	sw := &s3_writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := client.PutObject(context.Background(), bucket, key, pr, -1,
+			minio.PutObjectOptions{PartSize: s3_part_size, ContentType: "application/octet-stream"})
+		if err != nil {
+			err = fmt.Errorf("s3: can't upload %s: %s", dest, err)
+		}
+		// Writing fails from now on if the upload failed
+		pr.CloseWithError(err)
+		sw.done <- err
+	}()
+	return sw, nil
+}
+
+func (sw *s3_writer) Write(p []byte) (int, error) {
+	return sw.pw.Write(p)
+}
+
+// End the data and wait for the upload to complete
+func (sw *s3_writer) Close() error {
+	sw.pw.Close()
+	return <-sw.done
+}
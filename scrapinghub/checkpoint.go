@@ -0,0 +1,151 @@
+package scrapinghub
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Progress of the download of the lines of a job (items, log) to a file:
+// the offset of the next line to retrieve, the bytes of the file written
+// until then and their sha256 checksum (hex)
+type Checkpoint struct {
+	JobID    string `json:"job_id"`
+	Offset   int    `json:"offset"`
+	Position int64  `json:"position"`
+	Checksum string `json:"checksum"`
+}
+
+// Load the checkpoint saved in `path`. Returns nil (and no error) if the
+// file doesn't exist.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(content, &cp); err != nil {
+		return nil, fmt.Errorf("LoadCheckpoint: can't parse file %s: %s", path, err)
+	}
+	return &cp, nil
+}
+
+// Save the checkpoint to `path`
+func (cp *Checkpoint) Save(path string) error {
+	content, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, content)
+}
+
+// The CheckpointWriter writes the lines of a job to a file and saves
+// checkpoints of the progress to another file (`CheckpointPath`), so the
+// download can be resumed later from the last checkpoint saved.
+type CheckpointWriter struct {
+	JobID          string
+	CheckpointPath string
+	// Checkpoint loaded when the writer was opened, nil if the download
+	// starts from scratch
+	Resumed  *Checkpoint
+	file     *os.File
+	buf      *bufio.Writer
+	hash     hash.Hash
+	position int64
+}
+
+// Open the file `path` to write the lines of the job `job_id`. If there is
+// a checkpoint in `checkpoint_path` the file is verified against it and
+// truncated to its position to continue writing from there; otherwise the
+// file must be empty or not exist, and a first checkpoint is saved with
+// `offset` (the offset of the first line to download), so the download can
+// be resumed even if it's interrupted before the next checkpoint.
+func OpenCheckpointWriter(path, checkpoint_path, job_id string, offset int) (*CheckpointWriter, error) {
+	cp, err := LoadCheckpoint(checkpoint_path)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil && cp.JobID != job_id {
+		return nil, fmt.Errorf("CheckpointWriter: checkpoint %s is for job %s, not %s", checkpoint_path, cp.JobID, job_id)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cw := &CheckpointWriter{JobID: job_id, CheckpointPath: checkpoint_path, Resumed: cp, file: file, hash: sha256.New()}
+
+	if cp == nil {
+		if fi, err := file.Stat(); err != nil || fi.Size() > 0 {
+			file.Close()
+			return nil, fmt.Errorf("CheckpointWriter: %s is not empty and there is no checkpoint to resume it", path)
+		}
+	} else {
+		// Verify the bytes written until the checkpoint and drop the rest
+		n, err := io.CopyN(cw.hash, file, cp.Position)
+		if err != nil || n != cp.Position || hex.EncodeToString(cw.hash.Sum(nil)) != cp.Checksum {
+			file.Close()
+			return nil, fmt.Errorf("CheckpointWriter: %s doesn't match the checkpoint %s", path, checkpoint_path)
+		}
+		if err := file.Truncate(cp.Position); err != nil {
+			file.Close()
+			return nil, err
+		}
+		cw.position = cp.Position
+	}
+	if _, err := file.Seek(cw.position, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	cw.buf = bufio.NewWriter(file)
+	if cp == nil {
+		if err := cw.Save(offset); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return cw, nil
+}
+
+func (cw *CheckpointWriter) Write(p []byte) (int, error) {
+	n, err := cw.buf.Write(p)
+	cw.hash.Write(p[:n])
+	cw.position += int64(n)
+	return n, err
+}
+
+// Flush the lines written and save a checkpoint with `offset` as the offset
+// of the next line to write
+func (cw *CheckpointWriter) Save(offset int) error {
+	if err := cw.buf.Flush(); err != nil {
+		return err
+	}
+	if err := cw.file.Sync(); err != nil {
+		return err
+	}
+	cp := Checkpoint{
+		JobID:    cw.JobID,
+		Offset:   offset,
+		Position: cw.position,
+		Checksum: hex.EncodeToString(cw.hash.Sum(nil)),
+	}
+	return cp.Save(cw.CheckpointPath)
+}
+
+// Flush the lines written and close the file. Lines written after the last
+// checkpoint will be written again if the download is resumed.
+func (cw *CheckpointWriter) Close() error {
+	if err := cw.buf.Flush(); err != nil {
+		cw.file.Close()
+		return err
+	}
+	return cw.file.Close()
+}
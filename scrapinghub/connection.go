@@ -13,6 +13,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -46,6 +48,49 @@ type Connection struct {
 	user_agent    string
 	BaseUrl       string
 	ParsedBaseUrl url.URL
+	stats         map[string]*APICallStats
+	stats_mu      sync.Mutex
+}
+
+// Statistics of the calls done to an API method: number of calls, number
+// of calls failed (connection errors or HTTP status >= 400) and the total
+// time waiting for the responses
+type APICallStats struct {
+	Calls    int
+	Errors   int
+	Duration time.Duration
+}
+
+// Take note of a call to the API method `method`
+func (conn *Connection) recordCall(method string, elapsed time.Duration, resp *http.Response, err error) {
+	conn.stats_mu.Lock()
+	defer conn.stats_mu.Unlock()
+	if conn.stats == nil {
+		conn.stats = make(map[string]*APICallStats)
+	}
+	method = strings.TrimPrefix(method, "/")
+	st, ok := conn.stats[method]
+	if !ok {
+		st = &APICallStats{}
+		conn.stats[method] = st
+	}
+	st.Calls++
+	st.Duration += elapsed
+	if err != nil || resp.StatusCode >= 400 {
+		st.Errors++
+	}
+}
+
+// Returns the statistics of the calls done through the connection by API
+// method
+func (conn *Connection) Stats() map[string]APICallStats {
+	conn.stats_mu.Lock()
+	defer conn.stats_mu.Unlock()
+	stats := make(map[string]APICallStats)
+	for method, st := range conn.stats {
+		stats[method] = *st
+	}
+	return stats
 }
 
 // Create a new connection to Scrapinghub API
@@ -54,9 +99,10 @@ func (conn *Connection) New(apikey string) (err error) {
 	tlsConfig := tls.Config{RootCAs: nil}
 	ConnectionTimeout := time.Duration(60 * time.Second)
 
+	// Compression is enabled: the responses are requested with
+	// Accept-Encoding: gzip and decompressed transparently
 	tr := &http.Transport{
-		TLSClientConfig:    &tlsConfig,
-		DisableCompression: true,
+		TLSClientConfig: &tlsConfig,
 		Dial: func(network, addr string) (net.Conn, error) {
 			return net.DialTimeout(network, addr, ConnectionTimeout)
 		},
@@ -115,7 +161,10 @@ func (conn *Connection) APICall(method string, http_method HttpVerb, params *url
 	// Set Scrapinghub api key to request
 	req.SetBasicAuth(conn.apikey, "")
 	req.Header.Add("User-Agent", conn.user_agent)
-	return conn.client.Do(req)
+	start := time.Now()
+	resp, err := conn.client.Do(req)
+	conn.recordCall(method, time.Since(start), resp, err)
+	return resp, err
 }
 
 // Equal to APICall(method, http_method, params) but reads the body of the response
@@ -170,7 +219,9 @@ func (conn *Connection) APIPostFilesReadBody(method string, params *url.Values,
 	// Set Scrapinghub api key to request
 	req.SetBasicAuth(conn.apikey, "")
 	req.Header.Add("User-Agent", conn.user_agent)
+	start := time.Now()
 	resp, err := conn.client.Do(req)
+	conn.recordCall(method, time.Since(start), resp, err)
 	if err != nil {
 		return nil, err
 	}
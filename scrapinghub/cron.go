@@ -0,0 +1,170 @@
+package scrapinghub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Represent a parsed cron expression with the five standard fields:
+// minute, hour, day of month, month and day of week
+type CronExpr struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// true when the day of month/week field is restricted: like in Vixie
+	// cron, a field starting with `*` (e.g: `*`, `*/2`) is not restricted
+	dom_restricted bool
+	dow_restricted bool
+}
+
+var cron_macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cron_months = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cron_weekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parse a cron expression like "*/15 8-18 * * mon-fri" or a macro like
+// "@daily". Returns an error if the expression is not valid.
+func ParseCron(expr string) (*CronExpr, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cron_macros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("ParseCron: expression '%s' must have 5 fields", expr)
+	}
+
+	var c CronExpr
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("ParseCron: wrong minute field in '%s': %s", expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("ParseCron: wrong hour field in '%s': %s", expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("ParseCron: wrong day of month field in '%s': %s", expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cron_months); err != nil {
+		return nil, fmt.Errorf("ParseCron: wrong month field in '%s': %s", expr, err)
+	}
+	// 7 is accepted as an alias for sunday
+	if c.dow, err = parseCronField(fields[4], 0, 7, cron_weekdays); err != nil {
+		return nil, fmt.Errorf("ParseCron: wrong day of week field in '%s': %s", expr, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.dom_restricted = !strings.HasPrefix(fields[2], "*")
+	c.dow_restricted = !strings.HasPrefix(fields[4], "*")
+	return &c, nil
+}
+
+// Parse one field of a cron expression (e.g: "1,5,10-20/2") and returns a
+// bitset with the values allowed
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("bad step '%s'", part[i+1:])
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = parseCronValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "N/step" means from N to the maximum
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range '%s' (%d-%d)", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value '%s'", s)
+	}
+	return v, nil
+}
+
+func (c *CronExpr) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	// Like in cron, when both fields are restricted the day matches if any
+	// of them match, e.g: `0 0 1 * 1` runs on the 1st and on Mondays but
+	// `0 0 */2 * 1` only on the Mondays of odd days
+	if c.dom_restricted && c.dow_restricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Returns the first time after `t` matching the expression. Returns the zero
+// time if there is no such time in the next five years (e.g: "0 0 30 2 *").
+func (c *CronExpr) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,89 @@
+package scrapinghub
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Find the duplicated items of one or many jobs by the values of some fields
+// (nested fields with dots, e.g: offer.url) or by the hash of their content
+// if no field is given. The keys seen are kept in a DiskSet, so the number
+// of items is not limited by the memory.
+type Deduplicator struct {
+	Fields []string
+	paths  [][]string
+	set    *DiskSet
+}
+
+// Returns a deduplicator by the `fields` given (all the content if none),
+// with the keys seen stored in a temporary directory in `dir`
+func NewDeduplicator(fields []string, dir string) (*Deduplicator, error) {
+	d := &Deduplicator{}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		d.Fields = append(d.Fields, field)
+		d.paths = append(d.paths, strings.Split(field, "."))
+	}
+	set, err := NewDiskSet(dir, 0)
+	if err != nil {
+		return nil, fmt.Errorf("NewDeduplicator: %s", err)
+	}
+	d.set = set
+	return d, nil
+}
+
+// Returns the key of the item: the JSON list of the values of the fields,
+// or the hash of the content of the item. The key is "" if the item has
+// none of the fields.
+func (d *Deduplicator) Key(item map[string]interface{}) (string, error) {
+	if len(d.paths) == 0 {
+		// json.Marshal sorts the map keys, so the encoding is canonical
+		canonical, err := json.Marshal(item)
+		if err != nil {
+			return "", err
+		}
+		sum := sha1.Sum(canonical)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	values := make([]interface{}, len(d.paths))
+	found := false
+	for i, path := range d.paths {
+		if v, ok := lookupPath(item, path); ok {
+			values[i] = v
+			found = true
+		}
+	}
+	if !found {
+		return "", nil
+	}
+	key, err := json.Marshal(values)
+	return string(key), err
+}
+
+// Returns the key of the item and whether an item with the same key was
+// seen before. Items without key are never duplicates.
+func (d *Deduplicator) Check(item map[string]interface{}) (string, bool, error) {
+	key, err := d.Key(item)
+	if err != nil {
+		return "", false, fmt.Errorf("Deduplicator.Check: %s", err)
+	}
+	if key == "" {
+		return "", false, nil
+	}
+	added, err := d.set.Add([]byte(key))
+	if err != nil {
+		return key, false, fmt.Errorf("Deduplicator.Check: %s", err)
+	}
+	return key, !added, nil
+}
+
+// Remove the keys stored
+func (d *Deduplicator) Close() error {
+	return d.set.Close()
+}
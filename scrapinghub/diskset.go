@@ -0,0 +1,279 @@
+package scrapinghub
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Size of the hashes of the keys stored in a DiskSet
+const setHashSize = 16
+
+// Number of runs on disk merged into one when they reach it
+const setMaxRuns = 8
+
+// Set of keys which scales past the memory: the hashes of the keys are kept
+// in memory up to MaxMemory, then written sorted to a file (a run) in a
+// temporary directory. A key is looked up in memory and with a binary search
+// in the runs, which have a bloom filter to skip most of the reads, e.g:
+//
+//	set, err := NewDiskSet("", 0)
+//	...
+//	defer set.Close()
+//	added, err := set.Add([]byte(url))
+type DiskSet struct {
+	// Number of hashes kept in memory before writing them to disk
+	MaxMemory int
+	dir       string
+	mem       map[[setHashSize]byte]struct{}
+	runs      []*setRun
+	count     int
+	next_run  int
+}
+
+// Sorted hashes in a file, with a bloom filter
+type setRun struct {
+	file  *os.File
+	n     int
+	bloom []uint64
+}
+
+// Returns a new set with its files in a temporary directory created in `dir`
+// (the default temporary directory if ""), keeping up to `max_memory` hashes
+// in memory (1M, about 40MB, if 0)
+func NewDiskSet(dir string, max_memory int) (*DiskSet, error) {
+	tmp, err := ioutil.TempDir(dir, "shubc-set")
+	if err != nil {
+		return nil, fmt.Errorf("NewDiskSet: %s", err)
+	}
+	if max_memory <= 0 {
+		max_memory = 1 << 20
+	}
+	return &DiskSet{MaxMemory: max_memory, dir: tmp, mem: make(map[[setHashSize]byte]struct{})}, nil
+}
+
+func setHash(key []byte) [setHashSize]byte {
+	var h [setHashSize]byte
+	sum := sha1.Sum(key)
+	copy(h[:], sum[:])
+	return h
+}
+
+// Bit positions of the hash in a bloom filter of `bits` bits (7 hashes
+// derived from the two halves of the hash)
+func bloomBits(h [setHashSize]byte, bits uint64) [7]uint64 {
+	h1 := binary.BigEndian.Uint64(h[:8])
+	h2 := binary.BigEndian.Uint64(h[8:])
+	var pos [7]uint64
+	for i := range pos {
+		pos[i] = (h1 + uint64(i)*h2) % bits
+	}
+	return pos
+}
+
+func (r *setRun) mayContain(h [setHashSize]byte) bool {
+	for _, p := range bloomBits(h, uint64(len(r.bloom))*64) {
+		if r.bloom[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *setRun) contains(h [setHashSize]byte) (bool, error) {
+	if !r.mayContain(h) {
+		return false, nil
+	}
+	var buf [setHashSize]byte
+	var read_err error
+	i := sort.Search(r.n, func(i int) bool {
+		if read_err != nil {
+			return true
+		}
+		if _, err := r.file.ReadAt(buf[:], int64(i)*setHashSize); err != nil {
+			read_err = err
+			return true
+		}
+		return bytes.Compare(buf[:], h[:]) >= 0
+	})
+	if read_err != nil {
+		return false, read_err
+	}
+	if i == r.n {
+		return false, nil
+	}
+	if _, err := r.file.ReadAt(buf[:], int64(i)*setHashSize); err != nil {
+		return false, err
+	}
+	return buf == h, nil
+}
+
+func (s *DiskSet) contains(h [setHashSize]byte) (bool, error) {
+	if _, ok := s.mem[h]; ok {
+		return true, nil
+	}
+	for _, r := range s.runs {
+		found, err := r.contains(h)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// Returns whether the key is in the set
+func (s *DiskSet) Contains(key []byte) (bool, error) {
+	found, err := s.contains(setHash(key))
+	if err != nil {
+		return false, fmt.Errorf("DiskSet.Contains: %s", err)
+	}
+	return found, nil
+}
+
+// Add the key to the set, returns false if it was already in the set
+func (s *DiskSet) Add(key []byte) (bool, error) {
+	h := setHash(key)
+	found, err := s.contains(h)
+	if err != nil {
+		return false, fmt.Errorf("DiskSet.Add: %s", err)
+	}
+	if found {
+		return false, nil
+	}
+	s.mem[h] = struct{}{}
+	s.count++
+	if len(s.mem) >= s.MaxMemory {
+		if err := s.spill(); err != nil {
+			return true, fmt.Errorf("DiskSet.Add: %s", err)
+		}
+	}
+	return true, nil
+}
+
+// Returns the number of keys in the set
+func (s *DiskSet) Len() int {
+	return s.count
+}
+
+// Create a run writing the hashes given by `next` (in order) to a new file
+func (s *DiskSet) newRun(n int, next func() ([setHashSize]byte, error)) (*setRun, error) {
+	s.next_run++
+	file, err := os.Create(filepath.Join(s.dir, fmt.Sprintf("run-%d", s.next_run)))
+	if err != nil {
+		return nil, err
+	}
+	// 10 bits per hash, about 1% of false positives with 7 hashes
+	r := &setRun{file: file, n: n, bloom: make([]uint64, (n*10+63)/64+1)}
+	bits := uint64(len(r.bloom)) * 64
+	w := bufio.NewWriter(file)
+	for i := 0; i < n; i++ {
+		h, err := next()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if _, err := w.Write(h[:]); err != nil {
+			file.Close()
+			return nil, err
+		}
+		for _, p := range bloomBits(h, bits) {
+			r.bloom[p/64] |= 1 << (p % 64)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write the hashes in memory to a new run, merging the runs if there are
+// too many of them
+func (s *DiskSet) spill() error {
+	hashes := make([][setHashSize]byte, 0, len(s.mem))
+	for h := range s.mem {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	i := 0
+	r, err := s.newRun(len(hashes), func() ([setHashSize]byte, error) {
+		i++
+		return hashes[i-1], nil
+	})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, r)
+	s.mem = make(map[[setHashSize]byte]struct{})
+	if len(s.runs) >= setMaxRuns {
+		return s.merge()
+	}
+	return nil
+}
+
+// Merge all the runs into one (the runs have no hashes in common)
+func (s *DiskSet) merge() error {
+	readers := make([]*bufio.Reader, len(s.runs))
+	heads := make([][setHashSize]byte, len(s.runs))
+	valid := make([]bool, len(s.runs))
+	remaining := make([]int, len(s.runs))
+	// Read the next hash of the run `i` into its head
+	next := func(i int) error {
+		if remaining[i] == 0 {
+			valid[i] = false
+			return nil
+		}
+		remaining[i]--
+		_, err := io.ReadFull(readers[i], heads[i][:])
+		valid[i] = err == nil
+		return err
+	}
+	total := 0
+	for i, r := range s.runs {
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		readers[i] = bufio.NewReader(r.file)
+		remaining[i] = r.n
+		total += r.n
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+	merged, err := s.newRun(total, func() ([setHashSize]byte, error) {
+		min := -1
+		for i := range heads {
+			if valid[i] && (min < 0 || bytes.Compare(heads[i][:], heads[min][:]) < 0) {
+				min = i
+			}
+		}
+		h := heads[min]
+		return h, next(min)
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range s.runs {
+		r.file.Close()
+		os.Remove(r.file.Name())
+	}
+	s.runs = []*setRun{merged}
+	return nil
+}
+
+// Remove the files of the set
+func (s *DiskSet) Close() error {
+	for _, r := range s.runs {
+		r.file.Close()
+	}
+	s.runs = nil
+	s.mem = nil
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,473 @@
+package scrapinghub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter and projection of items, e.g:
+//
+//	f, err := NewItemFilter(`price > 10 && category == "books"`, []string{"name", "price", "url"})
+//	...
+//	if item, ok := f.Apply(item); ok {
+//		...
+//	}
+//
+// The `where` expression supports the comparisons ==, !=, <, <=, >, >= and
+// =~ (regular expression), the logical operators &&, || and !, parentheses,
+// the literals numbers, "strings" (or 'strings'), true, false and null, and
+// the fields of the items, nested fields with dots (e.g: price.amount, or
+// tags.0 for the first element of a list). A field alone is true unless it's
+// missing, null or false. Comparisons of values of different types are false
+// (except !=).
+type ItemFilter struct {
+	Where  string
+	Select []string
+	expr   filterExpr
+	paths  [][]string
+}
+
+type filterExpr func(item map[string]interface{}) interface{}
+
+// Returns the filter of the items matching the expression `where` (all the
+// items if "") keeping only the `fields` given (all the fields if none)
+func NewItemFilter(where string, fields []string) (*ItemFilter, error) {
+	f := &ItemFilter{Where: where}
+	if strings.TrimSpace(where) != "" {
+		p := &filterParser{src: where}
+		if err := p.tokenize(); err != nil {
+			return nil, fmt.Errorf("ItemFilter: %s", err)
+		}
+		expr, err := p.parseOr()
+		if err == nil && p.pos < len(p.tokens) {
+			err = p.errorf("unexpected %s", p.tokens[p.pos].text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ItemFilter: %s", err)
+		}
+		f.expr = expr
+	}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		f.Select = append(f.Select, field)
+		f.paths = append(f.paths, strings.Split(field, "."))
+	}
+	return f, nil
+}
+
+// Returns whether the item matches the `where` expression
+func (f *ItemFilter) Match(item map[string]interface{}) bool {
+	if f.expr == nil {
+		return true
+	}
+	return truthy(f.expr(item))
+}
+
+// Returns a new item with only the selected fields (nested fields are kept
+// nested), or the item itself if no field was selected. The elements of
+// lists selected (e.g: tags.1) are kept in a list at their positions, with
+// null for the elements not selected before them.
+func (f *ItemFilter) Project(item map[string]interface{}) map[string]interface{} {
+	if len(f.paths) == 0 {
+		return item
+	}
+	out := make(map[string]interface{}, len(f.paths))
+	for _, path := range f.paths {
+		if _, ok := lookupPath(item, path); ok {
+			projectPath(out, item, path)
+		}
+	}
+	return out
+}
+
+// Copy the value of the field `path` (it must exist) of `src` to `dst`,
+// building the objects and lists in the path. Returns `dst`.
+func projectPath(dst, src interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return src
+	}
+	switch val := src.(type) {
+	case map[string]interface{}:
+		node, ok := dst.(map[string]interface{})
+		if !ok {
+			node = make(map[string]interface{})
+		}
+		node[path[0]] = projectPath(node[path[0]], val[path[0]], path[1:])
+		return node
+	case []interface{}:
+		i, _ := strconv.Atoi(path[0])
+		node, _ := dst.([]interface{})
+		if len(node) <= i {
+			node = append(node, make([]interface{}, i+1-len(node))...)
+		}
+		node[i] = projectPath(node[i], val[i], path[1:])
+		return node
+	}
+	return src
+}
+
+// Returns the item projected and whether it matches the filter
+func (f *ItemFilter) Apply(item map[string]interface{}) (map[string]interface{}, bool) {
+	if !f.Match(item) {
+		return nil, false
+	}
+	return f.Project(item), true
+}
+
+// Apply the filter to an item encoded as a JSON line (e.g: a line of
+// ItemsAsJsonLines). The line is returned as is if it doesn't need to be
+// projected.
+func (f *ItemFilter) ApplyLine(line string) (string, bool, error) {
+	if f.expr == nil && len(f.paths) == 0 {
+		return line, true, nil
+	}
+	dec := json.NewDecoder(bytes.NewBufferString(line))
+	dec.UseNumber()
+	var item map[string]interface{}
+	if err := dec.Decode(&item); err != nil {
+		return "", false, fmt.Errorf("ItemFilter.ApplyLine: can't decode item: %s", err)
+	}
+	item, ok := f.Apply(item)
+	if !ok {
+		return "", false, nil
+	}
+	if len(f.paths) == 0 {
+		return line, true, nil
+	}
+	b, err := json.Marshal(item)
+	if err != nil {
+		return "", false, fmt.Errorf("ItemFilter.ApplyLine: %s", err)
+	}
+	return string(b), true, nil
+}
+
+// Returns the value of the nested field `path` of the item
+func lookupPath(item map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = item
+	for _, key := range path {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			child, ok := val[key]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(val) {
+				return nil, false
+			}
+			v = val[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	}
+	return true
+}
+
+// Returns the number of a value decoded from JSON (with or without UseNumber)
+func toNumber(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case json.Number:
+		n, err := val.Float64()
+		return n, err == nil
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+// Returns -1, 0 or 1 comparing a and b, false if they can't be compared
+func compareValues(a, b interface{}) (int, bool) {
+	if na, ok := toNumber(a); ok {
+		nb, ok := toNumber(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case na < nb:
+			return -1, true
+		case na > nb:
+			return 1, true
+		}
+		return 0, true
+	}
+	if sa, ok := a.(string); ok {
+		sb, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(sa, sb), true
+	}
+	return 0, false
+}
+
+func equalValues(a, b interface{}) bool {
+	if c, ok := compareValues(a, b); ok {
+		return c == 0
+	}
+	switch a.(type) {
+	case nil, bool:
+		return a == b
+	}
+	// Objects and lists are compared by their JSON
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
+}
+
+type filterToken struct {
+	kind string // op, number, string, ident
+	text string
+	pos  int
+	val  interface{}
+}
+
+type filterParser struct {
+	src    string
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	at := len(p.src)
+	if p.pos < len(p.tokens) {
+		at = p.tokens[p.pos].pos
+	}
+	return fmt.Errorf("%s at position %d of %q", fmt.Sprintf(format, args...), at+1, p.src)
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *filterParser) tokenize() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "&&"), strings.HasPrefix(src[i:], "||"),
+			strings.HasPrefix(src[i:], "=="), strings.HasPrefix(src[i:], "!="),
+			strings.HasPrefix(src[i:], "<="), strings.HasPrefix(src[i:], ">="),
+			strings.HasPrefix(src[i:], "=~"):
+			p.tokens = append(p.tokens, filterToken{kind: "op", text: src[i : i+2], pos: i})
+			i += 2
+		case strings.ContainsRune("<>!()", rune(c)):
+			p.tokens = append(p.tokens, filterToken{kind: "op", text: string(c), pos: i})
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return fmt.Errorf("unterminated string at position %d of %q", i+1, src)
+			}
+			p.tokens = append(p.tokens, filterToken{kind: "string", text: src[i : j+1], pos: i, val: sb.String()})
+			i = j + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(src) && strings.IndexByte("0123456789.eE+-", src[j]) >= 0 {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return fmt.Errorf("wrong number %s at position %d of %q", src[i:j], i+1, src)
+			}
+			p.tokens = append(p.tokens, filterToken{kind: "number", text: src[i:j], pos: i, val: n})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(src) && isIdentChar(src[j]) {
+				j++
+			}
+			p.tokens = append(p.tokens, filterToken{kind: "ident", text: src[i:j], pos: i})
+			i = j
+		default:
+			return fmt.Errorf("unexpected %q at position %d of %q", c, i+1, src)
+		}
+	}
+	return nil
+}
+
+func (p *filterParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == "op" && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item map[string]interface{}) interface{} {
+			return truthy(l(item)) || truthy(right(item))
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item map[string]interface{}) interface{} {
+			return truthy(l(item)) && truthy(right(item))
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.accept("!") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(item map[string]interface{}) interface{} { return !truthy(e(item)) }, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "op" {
+		return left, nil
+	}
+	op := p.tokens[p.pos].text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	case "=~":
+		p.pos++
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "string" {
+			return nil, p.errorf("=~ requires a string with the regular expression")
+		}
+		re, err := regexp.Compile(p.tokens[p.pos].val.(string))
+		if err != nil {
+			return nil, p.errorf("wrong regular expression: %s", err)
+		}
+		p.pos++
+		return func(item map[string]interface{}) interface{} {
+			s, ok := left(item).(string)
+			return ok && re.MatchString(s)
+		}, nil
+	default:
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return func(item map[string]interface{}) interface{} {
+		a, b := left(item), right(item)
+		switch op {
+		case "==":
+			return equalValues(a, b)
+		case "!=":
+			return !equalValues(a, b)
+		}
+		c, ok := compareValues(a, b)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+		return c >= 0
+	}, nil
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorf("unexpected end of the expression")
+	}
+	tok := p.tokens[p.pos]
+	switch tok.kind {
+	case "op":
+		if !p.accept("(") {
+			return nil, p.errorf("unexpected %s", tok.text)
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("missing )")
+		}
+		return e, nil
+	case "number", "string":
+		p.pos++
+		val := tok.val
+		return func(item map[string]interface{}) interface{} { return val }, nil
+	}
+	p.pos++
+	switch tok.text {
+	case "true":
+		return func(item map[string]interface{}) interface{} { return true }, nil
+	case "false":
+		return func(item map[string]interface{}) interface{} { return false }, nil
+	case "null":
+		return func(item map[string]interface{}) interface{} { return nil }, nil
+	}
+	path := strings.Split(tok.text, ".")
+	for _, key := range path {
+		if key == "" {
+			p.pos--
+			return nil, p.errorf("wrong field %s", tok.text)
+		}
+	}
+	return func(item map[string]interface{}) interface{} {
+		v, _ := lookupPath(item, path)
+		return v
+	}, nil
+}
@@ -0,0 +1,135 @@
+package scrapinghub
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options to flatten the nested values of the items (see FlattenItem)
+type FlattenOptions struct {
+	// Separator between the keys of nested objects, default "."
+	Separator string
+	// Return a row for every element of the lists instead of joining them
+	ExplodeLists bool
+	// Separator of the elements of the lists joined, default "|"
+	ListSeparator string
+}
+
+// Returns the value as a string: numbers without exponent, null as ""
+func flatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case json.Number:
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Returns the cartesian product of the rows `a` and `b`, or an error if a
+// column is in both (e.g: the key "a.b" and the nested field {"a":{"b":..}})
+func crossRows(a, b []map[string]string) ([]map[string]string, error) {
+	rows := make([]map[string]string, 0, len(a)*len(b))
+	for _, ra := range a {
+		for _, rb := range b {
+			row := make(map[string]string, len(ra)+len(rb))
+			for k, v := range ra {
+				row[k] = v
+			}
+			for k, v := range rb {
+				if _, ok := row[k]; ok {
+					return nil, fmt.Errorf("FlattenItem: several fields are flattened to the column %s", k)
+				}
+				row[k] = v
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// Returns the rows of the value `v` in columns named from `prefix`, empty
+// objects and lists are written as {} and []
+func flatten(prefix string, v interface{}, opts *FlattenOptions) ([]map[string]string, error) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 && prefix != "" {
+			return []map[string]string{{prefix: "{}"}}, nil
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		rows := []map[string]string{{}}
+		for _, k := range keys {
+			key := k
+			if prefix != "" {
+				key = prefix + opts.Separator + k
+			}
+			krows, err := flatten(key, val[k], opts)
+			if err != nil {
+				return nil, err
+			}
+			if rows, err = crossRows(rows, krows); err != nil {
+				return nil, err
+			}
+		}
+		return rows, nil
+	case []interface{}:
+		if len(val) == 0 {
+			return []map[string]string{{prefix: "[]"}}, nil
+		}
+		if opts.ExplodeLists {
+			var rows []map[string]string
+			for _, e := range val {
+				erows, err := flatten(prefix, e, opts)
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, erows...)
+			}
+			return rows, nil
+		}
+		parts := make([]string, len(val))
+		for i, e := range val {
+			parts[i] = flatValue(e)
+		}
+		return []map[string]string{{prefix: strings.Join(parts, opts.ListSeparator)}}, nil
+	}
+	return []map[string]string{{prefix: flatValue(v)}}, nil
+}
+
+// Flatten the nested objects of the item into columns named with the keys
+// joined by the separator (e.g: price.amount). Lists are joined, or
+// exploded into a row per element (the product of the rows of every list if
+// there are several). Empty objects and lists are written as {} and []. Two
+// fields flattened to the same column (e.g: the key "a.b" and the nested
+// field {"a":{"b":..}}) are an error. Returns the rows with the values as
+// strings.
+func FlattenItem(item map[string]interface{}, opts *FlattenOptions) ([]map[string]string, error) {
+	o := FlattenOptions{Separator: ".", ListSeparator: "|"}
+	if opts != nil {
+		o.ExplodeLists = opts.ExplodeLists
+		if opts.Separator != "" {
+			o.Separator = opts.Separator
+		}
+		if opts.ListSeparator != "" {
+			o.ListSeparator = opts.ListSeparator
+		}
+	}
+	if item == nil {
+		return nil, fmt.Errorf("FlattenItem: the item is null")
+	}
+	return flatten("", item, &o)
+}
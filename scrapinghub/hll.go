@@ -0,0 +1,61 @@
+package scrapinghub
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Precision of the estimates: 2^hllPrecision registers (4KB), the standard
+// error is about 1.04 / sqrt(2^hllPrecision) = 1.6%
+const hllPrecision = 12
+
+// Estimate of the number of distinct values added (HyperLogLog), using a
+// fixed amount of memory whatever the number of values
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// Mix the bits of the hash (FNV alone is not uniform enough in the high bits)
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (hll *HyperLogLog) Add(value string) {
+	f := fnv.New64a()
+	f.Write([]byte(value))
+	h := mix64(f.Sum64())
+	index := h >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > hll.registers[index] {
+		hll.registers[index] = rank
+	}
+}
+
+// Returns the estimated number of distinct values
+func (hll *HyperLogLog) Count() uint64 {
+	m := float64(len(hll.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range hll.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// Small cardinalities are estimated better counting the empty registers
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -2,8 +2,12 @@ package scrapinghub
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
 // Returns up to `count` items for the job `job_id`, starting at `offset`. Each
@@ -32,11 +36,211 @@ func RetrieveItems(conn *Connection, job_id string, count, offset int) ([]map[st
 	if err != nil {
 		return nil, err
 	}
-	jarray := f.([]interface{})
+	jarray, ok := f.([]interface{})
+	if !ok {
+		// The API returns an object with the error message instead of a list
+		if obj, ok := f.(map[string]interface{}); ok && obj["message"] != nil {
+			return nil, fmt.Errorf("RetrieveItems: Error while retrieving the items. Message: %v", obj["message"])
+		}
+		return nil, fmt.Errorf("RetrieveItems: unexpected response, expected a list of items")
+	}
 
 	items := make([]map[string]interface{}, len(jarray))
 	for i, e := range jarray {
-		items[i] = e.(map[string]interface{})
+		item, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("RetrieveItems: unexpected item at offset %d, expected an object", offset+i)
+		}
+		items[i] = item
 	}
 	return items, nil
 }
+
+// Error decoding the item at position `Offset` of a job
+type ItemDecodeError struct {
+	Offset int
+	Line   string
+	Err    error
+}
+
+func (e *ItemDecodeError) Error() string {
+	return fmt.Sprintf("Items: can't decode item at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *ItemDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// An item of a job decoded into the type T, with its position in the job.
+// If the item couldn't be decoded `Err` is an *ItemDecodeError.
+type ItemResult[T any] struct {
+	Item   T
+	Offset int
+	Err    error
+}
+
+// Returns a channel with the items of the job `job_id` decoded one by one
+// into values of type T (usually a struct with json tags), using the count
+// and offset of `ls`. Items which can't be decoded are returned with their
+// error and the stream goes on. Returns a channel with errors
+func Items[T any](ls *LinesStream, job_id string) (<-chan ItemResult[T], <-chan error) {
+	ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+	out := make(chan ItemResult[T])
+
+	go func() {
+		defer close(out)
+		offset := ls.Offset
+		for line := range ch_lines {
+			r := ItemResult[T]{Offset: offset}
+			if err := json.Unmarshal([]byte(line), &r.Item); err != nil {
+				r.Err = &ItemDecodeError{Offset: offset, Line: line, Err: err}
+			}
+			out <- r
+			offset++
+		}
+	}()
+	return out, errch
+}
+
+// Iterate the items of a job one by one, decoding them while they are read
+// from the API (items.jl, requested in batches of `BatchSize` items), so the
+// memory used doesn't depend on the number of items of the job, e.g:
+//
+//	it, err := NewItemIterator(conn, job_id, 0, 0)
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		item := it.Item()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ItemIterator struct {
+	Conn      *Connection
+	JobID     string
+	Count     int
+	BatchSize int
+	offset    int
+	read      int
+	current   int
+	item      map[string]interface{}
+	body      io.ReadCloser
+	dec       *json.Decoder
+	batch     int
+	in_batch  int
+	done      bool
+	err       error
+}
+
+// Returns an iterator over up to `count` items (all if 0) of the job
+// `job_id`, starting at `offset`
+func NewItemIterator(conn *Connection, job_id string, count, offset int) (*ItemIterator, error) {
+	if err := ValidateJobID(job_id); err != nil {
+		return nil, err
+	}
+	return &ItemIterator{Conn: conn, JobID: job_id, Count: count, offset: offset}, nil
+}
+
+func (it *ItemIterator) nextBatch() error {
+	batch := it.BatchSize
+	if batch <= 0 {
+		batch = 1000
+	}
+	if it.Count > 0 && it.Count-it.read < batch {
+		batch = it.Count - it.read
+	}
+	params := url.Values{}
+	params.Add("project", ProjectID(it.JobID))
+	params.Add("job", it.JobID)
+	params.Add("offset", strconv.Itoa(it.offset))
+	params.Add("count", strconv.Itoa(batch))
+
+	resp, err := it.Conn.APICall("/items.jl", GET, &params)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return fmt.Errorf("ItemIterator: Error while retrieving the items, status: %s, message: %s",
+			resp.Status, strings.TrimSpace(string(content)))
+	}
+	it.body = resp.Body
+	it.dec = json.NewDecoder(resp.Body)
+	it.batch = batch
+	it.in_batch = 0
+	return nil
+}
+
+// Advance to the next item. Returns false when there are no more items or
+// an error happened (see Err).
+func (it *ItemIterator) Next() bool {
+	for {
+		if it.done || it.err != nil {
+			return false
+		}
+		if it.Count > 0 && it.read >= it.Count {
+			it.Close()
+			return false
+		}
+		if it.dec == nil {
+			if it.err = it.nextBatch(); it.err != nil {
+				return false
+			}
+		}
+
+		var item map[string]interface{}
+		err := it.dec.Decode(&item)
+		if err == io.EOF {
+			it.closeBody()
+			// A short batch means that the end of the job was reached
+			if it.in_batch < it.batch {
+				it.done = true
+			}
+			continue
+		}
+		if err != nil {
+			it.err = fmt.Errorf("ItemIterator: can't decode item at offset %d: %s", it.offset, err)
+			it.closeBody()
+			return false
+		}
+		it.item = item
+		it.current = it.offset
+		it.offset++
+		it.read++
+		it.in_batch++
+		return true
+	}
+}
+
+// Returns the current item
+func (it *ItemIterator) Item() map[string]interface{} {
+	return it.item
+}
+
+// Returns the offset of the current item in the job
+func (it *ItemIterator) Offset() int {
+	return it.current
+}
+
+// Returns the error which stopped the iteration, if any
+func (it *ItemIterator) Err() error {
+	return it.err
+}
+
+func (it *ItemIterator) closeBody() error {
+	if it.body == nil {
+		return nil
+	}
+	err := it.body.Close()
+	it.body = nil
+	it.dec = nil
+	return err
+}
+
+// Stop the iteration and close the response being read, if any
+func (it *ItemIterator) Close() error {
+	it.done = true
+	return it.closeBody()
+}
@@ -0,0 +1,217 @@
+package scrapinghub
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Represent the difference of a numeric counter (items, errors, etc) between
+// two jobs
+type CounterDiff struct {
+	Name  string
+	A     int
+	B     int
+	Delta int
+}
+
+// Returns the percentage change of the counter from job A to job B and
+// false when it can't be computed (counter A is zero)
+func (cd *CounterDiff) PercentChange() (float64, bool) {
+	if cd.A == 0 {
+		return 0, false
+	}
+	return float64(cd.Delta) * 100.0 / float64(cd.A), true
+}
+
+// Represent the differences between the information of two jobs: spider
+// arguments, tags, version and counters
+type JobDiff struct {
+	JobA        *Job
+	JobB        *Job
+	ArgsAdded   map[string]string
+	ArgsRemoved map[string]string
+	// For every changed argument holds the values on job A and job B
+	ArgsChanged map[string][2]string
+	TagsAdded   []string
+	TagsRemoved []string
+	Counters    []CounterDiff
+}
+
+// Returns true if the jobs were run with different spider versions
+func (jd *JobDiff) VersionChanged() bool {
+	return jd.JobA.Version != jd.JobB.Version
+}
+
+// Compare the jobs `a` and `b` and returns their differences
+func DiffJobs(a, b *Job) *JobDiff {
+	jd := JobDiff{
+		JobA:        a,
+		JobB:        b,
+		ArgsAdded:   make(map[string]string),
+		ArgsRemoved: make(map[string]string),
+		ArgsChanged: make(map[string][2]string),
+	}
+	for k, va := range a.SpiderArgs {
+		vb, ok := b.SpiderArgs[k]
+		if !ok {
+			jd.ArgsRemoved[k] = va
+		} else if va != vb {
+			jd.ArgsChanged[k] = [2]string{va, vb}
+		}
+	}
+	for k, vb := range b.SpiderArgs {
+		if _, ok := a.SpiderArgs[k]; !ok {
+			jd.ArgsAdded[k] = vb
+		}
+	}
+	jd.TagsAdded = stringsDifference(b.Tags, a.Tags)
+	jd.TagsRemoved = stringsDifference(a.Tags, b.Tags)
+
+	counters := []struct {
+		name string
+		a, b int
+	}{
+		{"items_scraped", a.ItemsScraped, b.ItemsScraped},
+		{"errors_count", a.ErrorsCount, b.ErrorsCount},
+		{"responses_received", a.ResponsesReceived, b.ResponsesReceived},
+		{"logs", a.Logs, b.Logs},
+		{"elapsed", a.Elapsed, b.Elapsed},
+	}
+	for _, c := range counters {
+		jd.Counters = append(jd.Counters, CounterDiff{Name: c.name, A: c.a, B: c.b, Delta: c.b - c.a})
+	}
+	return &jd
+}
+
+// Returns the sorted elements of `a` which are not in `b`
+func stringsDifference(a, b []string) []string {
+	inb := make(map[string]bool)
+	for _, s := range b {
+		inb[s] = true
+	}
+	var result []string
+	for _, s := range a {
+		if !inb[s] {
+			result = append(result, s)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Represent the differences between the items of two jobs, the items are
+// matched using the value of the field `Key`. Added, Removed and Changed
+// holds the key values of the items encoded as JSON (e.g: `"abc"`, `12`), so
+// values of different types or numbers written differently (`1` and `1.0`)
+// are different keys.
+type ItemsDiff struct {
+	Key       string
+	Added     []string
+	Removed   []string
+	Changed   []string
+	Unchanged int
+	// Items without the `Key` field on job A and job B
+	MissingKeyA int
+	MissingKeyB int
+	// Items with the same key of a previous item of the same job, only the
+	// first item of every key is compared
+	DuplicateKeyA int
+	DuplicateKeyB int
+}
+
+// Stream the items of the jobs `job_a` and `job_b` and compare them using the
+// field `key` to match the items of both jobs. Only the hash of the items of
+// `job_a` is kept in memory.
+func (ls *LinesStream) DiffItems(job_a, job_b, key string) (*ItemsDiff, error) {
+	diff := ItemsDiff{Key: key}
+
+	hashes_a := make(map[string][sha1.Size]byte)
+	err := ls.eachItem(job_a, func(item map[string]interface{}, hash [sha1.Size]byte) {
+		kval, ok := item[key]
+		if !ok {
+			diff.MissingKeyA++
+			return
+		}
+		k := itemKey(kval)
+		if _, dup := hashes_a[k]; dup {
+			diff.DuplicateKeyA++
+			return
+		}
+		hashes_a[k] = hash
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen_b := make(map[string]bool)
+	err = ls.eachItem(job_b, func(item map[string]interface{}, hash [sha1.Size]byte) {
+		kval, ok := item[key]
+		if !ok {
+			diff.MissingKeyB++
+			return
+		}
+		k := itemKey(kval)
+		if seen_b[k] {
+			diff.DuplicateKeyB++
+			return
+		}
+		seen_b[k] = true
+		if ha, ok := hashes_a[k]; !ok {
+			diff.Added = append(diff.Added, k)
+		} else if ha != hash {
+			diff.Changed = append(diff.Changed, k)
+		} else {
+			diff.Unchanged++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range hashes_a {
+		if !seen_b[k] {
+			diff.Removed = append(diff.Removed, k)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return &diff, nil
+}
+
+// Returns the key of an item from the value of its key field: the JSON
+// encoding of the value as decoded with UseNumber (the keys of the objects
+// are sorted by json.Marshal)
+func itemKey(kval interface{}) string {
+	k, _ := json.Marshal(kval)
+	return string(k)
+}
+
+// Call `fn` for every item of the job `job_id` with the decoded item and a
+// hash of its content
+func (ls *LinesStream) eachItem(job_id string, fn func(map[string]interface{}, [sha1.Size]byte)) error {
+	ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+	var decode_err error
+	for line := range ch_lines {
+		if decode_err != nil {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		var item map[string]interface{}
+		if err := dec.Decode(&item); err != nil {
+			decode_err = fmt.Errorf("LinesStream.DiffItems: can't decode item of job %s: %s", job_id, err)
+			continue
+		}
+		// json.Marshal sorts the map keys, so the encoding is canonical
+		canonical, _ := json.Marshal(item)
+		fn(item, sha1.Sum(canonical))
+	}
+	for err := range errch {
+		return err
+	}
+	return decode_err
+}
@@ -6,27 +6,62 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 // Represent a Scrapinghub Job with all the fields returned
 // by the API
 type Job struct {
-	CloseReason       string `json:"close_reason"`
-	Elapsed           int
-	ErrorsCount       int `json:"errors_count"`
-	Id                string
-	ItemsScraped      int    `json:"items_scraped"`
-	SpiderType        string `json:"spider_type"`
-	ResponsesReceived int    `json:"responses_received"`
-	Logs              int
-	Priority          int
-	Spider            string
+	CloseReason       string            `json:"close_reason"`
+	Elapsed           int               `json:"elapsed"`
+	ErrorsCount       int               `json:"errors_count"`
+	Id                string            `json:"id"`
+	ItemsScraped      int               `json:"items_scraped"`
+	SpiderType        string            `json:"spider_type"`
+	ResponsesReceived int               `json:"responses_received"`
+	Logs              int               `json:"logs"`
+	Priority          int               `json:"priority"`
+	Spider            string            `json:"spider"`
 	SpiderArgs        map[string]string `json:"spider_args"`
 	StartedTime       string            `json:"started_time"`
-	State             string
-	Tags              []string
-	UpdatedTime       string `json:"updated_time"`
-	Version           string
+	State             string            `json:"state"`
+	Tags              []string          `json:"tags"`
+	UpdatedTime       string            `json:"updated_time"`
+	Version           string            `json:"version"`
+}
+
+// Returns true if the job finished with a close reason other than "finished"
+// (e.g: failed, memusage_exceeded, shutdown, cancelled)
+func (job *Job) Failed() bool {
+	return job.State == "finished" && job.CloseReason != "finished"
+}
+
+// Layouts of the times returned by the API (e.g: started_time)
+var job_time_layouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+func parseJobTime(value string) (time.Time, error) {
+	for _, layout := range job_time_layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Job: can't parse time '%s'", value)
+}
+
+// Returns the time when the job started (UTC)
+func (job *Job) Started() (time.Time, error) {
+	return parseJobTime(job.StartedTime)
+}
+
+// Returns the time when the job was updated for the last time (UTC), for a
+// finished job it's the time when it finished
+func (job *Job) Updated() (time.Time, error) {
+	return parseJobTime(job.UpdatedTime)
 }
 
 // Jobs is a collection of jobs, in some cases it may contain
@@ -70,6 +105,45 @@ func (jobs *Jobs) List(conn *Connection, project_id string, count int, filters m
 	return jobs, err
 }
 
+// Returns the jobs of `project_id` started since `since` and matching the
+// filters, paginating the jobs list from the most recent. Jobs not started
+// yet are not returned.
+func (jobs *Jobs) ListSince(conn *Connection, project_id string, since time.Time, filters map[string]string) ([]Job, error) {
+	const PAGE_SIZE = 500
+
+	var result []Job
+	params := make(map[string]string)
+	for k, v := range filters {
+		params[k] = v
+	}
+	for offset := 0; ; offset += PAGE_SIZE {
+		params["offset"] = strconv.Itoa(offset)
+		// Use a new Jobs object for every page, decoding on a previous list
+		// would mix the fields of different jobs
+		var page Jobs
+		list, err := page.List(conn, project_id, PAGE_SIZE, params)
+		if err != nil {
+			return nil, err
+		}
+		older := false
+		for _, job := range list.Jobs {
+			started, err := job.Started()
+			if err != nil {
+				continue
+			}
+			if started.Before(since) {
+				older = true
+				continue
+			}
+			result = append(result, job)
+		}
+		if older || len(list.Jobs) < PAGE_SIZE {
+			break
+		}
+	}
+	return result, nil
+}
+
 // Returns the job information in map object given the job_id
 func (jobs *Jobs) JobInfo(conn *Connection, job_id string) (*Job, error) {
 	if err := ValidateJobID(job_id); err != nil {
@@ -93,7 +167,8 @@ func (jobs *Jobs) JobInfo(conn *Connection, job_id string) (*Job, error) {
 }
 
 // Schedule the spider with name `spider_name` and arguments `args` on `project_id`.
-func (jobs *Jobs) Schedule(conn *Connection, project_id string, spider_name string, args map[string]string) (string, error) {
+// The job is tagged with the `tags` given (if any).
+func (jobs *Jobs) Schedule(conn *Connection, project_id string, spider_name string, args map[string]string, tags ...string) (string, error) {
 	if err := ValidateProjectID(project_id); err != nil {
 		return "", err
 	}
@@ -104,6 +179,9 @@ func (jobs *Jobs) Schedule(conn *Connection, project_id string, spider_name stri
 	for k, v := range args {
 		params.Set(k, v)
 	}
+	for _, tag := range tags {
+		params.Add("add_tag", tag)
+	}
 
 	content, err := conn.APICallReadBody("/schedule.json", POST, &params)
 	if err != nil {
@@ -118,20 +196,24 @@ func (jobs *Jobs) Reschedule(conn *Connection, job_id string) (string, error) {
 	if err := ValidateJobID(job_id); err != nil {
 		return "", err
 	}
-	project_id := ProjectID(job_id)
 
 	job, err := jobs.JobInfo(conn, job_id)
 	if err != nil {
 		return "", err
 	}
+	return jobs.rescheduleJob(conn, job, job.Tags)
+}
 
+// Schedule again the spider of `job` with the same parameters, tagging the
+// new job with `tags`
+func (jobs *Jobs) rescheduleJob(conn *Connection, job *Job, tags []string) (string, error) {
 	params := url.Values{}
-	params.Add("project", project_id)
+	params.Add("project", ProjectID(job.Id))
 	params.Add("spider", job.Spider)
 	for k, v := range job.SpiderArgs {
 		params.Set(k, v)
 	}
-	for _, tag := range job.Tags {
+	for _, tag := range tags {
 		params.Add("add_tag", tag)
 	}
 
@@ -9,12 +9,59 @@ import (
 	"time"
 )
 
+const (
+	lines_batch_size     = 1000
+	lines_max_retries    = 3
+	lines_retry_interval = time.Second * 30
+)
+
 // Type to make easier handle the operations of retrieve
 // streams of lines of API call methods
 type LinesStream struct {
 	Conn   *Connection
 	Count  int
 	Offset int
+	// If given, it's called after every batch of lines is retrieved with the
+	// offset of the next line, before sending it. When the line at `offset`
+	// is received all the previous ones were already received. The lines are
+	// retrieved in batches then, also without Count.
+	Checkpoint func(offset int)
+	// Number of batches retrieved concurrently for the lines of a job (items,
+	// log). If greater than 1, the lines up to the number of items or log
+	// lines of the job are retrieved in segments by `Workers` goroutines.
+	Workers int
+	// In parallel mode, send the lines of every segment as soon as it's
+	// retrieved instead of in order (Checkpoint is not called)
+	Unordered bool
+}
+
+// Call `f` until it succeeds, up to lines_max_retries times waiting
+// lines_retry_interval between the calls. Returns the last error otherwise.
+func withRetries(f func() error) error {
+	var err error
+	for i := 1; ; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		if i == lines_max_retries {
+			return fmt.Errorf("Max retries reached: %d, internal error message : %v\n", lines_max_retries, err)
+		}
+		time.Sleep(lines_retry_interval)
+	}
+}
+
+// Call the API method with GET, returning an error if the response status is
+// an error too
+func (ls *LinesStream) callAPI(method string, params *url.Values) (*http.Response, error) {
+	resp, err := ls.Conn.APICall(method, GET, params)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", method, resp.Status)
+	}
+	return resp, nil
 }
 
 // Retrieve an stream of lines from the connection `conn` and to API method `method`. `count`
@@ -22,14 +69,9 @@ type LinesStream struct {
 // on the position `offset`.
 // It behaves reliable when the connection drops or when the API is not available.
 func (ls *LinesStream) asLinesStream(method string, params *url.Values) (<-chan string, <-chan error) {
-	const (
-		BATCH_SIZE     = 1000
-		MAX_RETRIES    = 3
-		RETRY_INTERVAL = time.Second * 30
-	)
-
 	out := make(chan string)
-	errch := make(chan error)
+	// Buffered so the error is kept until the channel of lines is consumed
+	errch := make(chan error, 1)
 
 	go func() {
 		defer close(out)
@@ -39,11 +81,15 @@ func (ls *LinesStream) asLinesStream(method string, params *url.Values) (<-chan
 		var err error
 		count := ls.Count
 		offset := ls.Offset
-		in_count := BATCH_SIZE
+		in_count := lines_batch_size
 		scan_retries := 1
+		// Without a count all the lines are asked in one call, but with
+		// Checkpoint they're asked in batches until a short one, so there
+		// are checkpoints during the download
+		batches := count <= 0 && ls.Checkpoint != nil
 
 		for {
-			if count < BATCH_SIZE {
+			if !batches && count < lines_batch_size {
 				in_count = count
 			}
 			params.Set("offset", strconv.Itoa(offset))
@@ -51,19 +97,13 @@ func (ls *LinesStream) asLinesStream(method string, params *url.Values) (<-chan
 				params.Set("count", strconv.Itoa(in_count))
 			}
 
-			i := 1
-			for {
-				resp, err = ls.Conn.APICall(method, GET, params)
-				if err == nil && resp != nil && resp.StatusCode < 400 {
-					break
-				}
-				time.Sleep(RETRY_INTERVAL)
-				i++
-				if i == MAX_RETRIES {
-					close(out)
-					errch <- fmt.Errorf("Max retries reached: %d, internal error message : %v\n", MAX_RETRIES, err)
-					return
-				}
+			err = withRetries(func() (err error) {
+				resp, err = ls.callAPI(method, params)
+				return err
+			})
+			if err != nil {
+				errch <- err
+				return
 			}
 
 			scanner := bufio.NewScanner(resp.Body)
@@ -72,11 +112,11 @@ func (ls *LinesStream) asLinesStream(method string, params *url.Values) (<-chan
 				retrieved++
 				out <- scanner.Text()
 			}
+			resp.Body.Close()
 			if scanner.Err() != nil {
 				if retrieved == 0 {
 					scan_retries++
-					if scan_retries == MAX_RETRIES {
-						close(out)
+					if scan_retries == lines_max_retries {
 						errch <- scanner.Err()
 						return
 					}
@@ -84,10 +124,18 @@ func (ls *LinesStream) asLinesStream(method string, params *url.Values) (<-chan
 				offset += retrieved
 				count -= retrieved
 			} else {
-				offset += in_count
+				offset += retrieved
 				count -= in_count
+				// A short batch means that the end of the stream was reached
+				if retrieved < in_count {
+					count = 0
+					batches = false
+				}
+			}
+			if ls.Checkpoint != nil {
+				ls.Checkpoint(offset)
 			}
-			if count <= 0 {
+			if count <= 0 && !batches {
 				break
 			}
 		}
@@ -95,16 +143,192 @@ func (ls *LinesStream) asLinesStream(method string, params *url.Values) (<-chan
 	return out, errch
 }
 
+// Retrieve `count` lines from `offset` of the API method `method`, retrying
+// the whole segment on errors. If `full`, less than `count` lines is an error
+// too (the segment is not the last one, the next ones would be misplaced).
+func (ls *LinesStream) fetchSegment(method string, params *url.Values, offset, count int, full bool) ([]string, error) {
+	seg_params := url.Values{}
+	for k, v := range *params {
+		seg_params[k] = v
+	}
+	seg_params.Set("offset", strconv.Itoa(offset))
+	seg_params.Set("count", strconv.Itoa(count))
+
+	lines := make([]string, 0, count)
+	err := withRetries(func() error {
+		lines = lines[:0]
+		resp, err := ls.callAPI(method, &seg_params)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if full && len(lines) < count {
+			return fmt.Errorf("%s returned %d lines from offset %d, expected %d", method, len(lines), offset, count)
+		}
+		return nil
+	})
+	return lines, err
+}
+
+type lines_segment struct {
+	index  int
+	offset int
+	lines  []string
+	full   bool
+	err    error
+}
+
+// Like asLinesStream but the range of lines from the offset of the stream to
+// `total` (or the count) is split in segments retrieved concurrently by
+// `Workers` goroutines. Up to 2 * `Workers` segments are kept in memory.
+// `total` may be behind the lines available (e.g: a running job), so if the
+// last segment is complete the lines after it are retrieved sequentially
+// until the end of the stream (or the count).
+func (ls *LinesStream) asParallelLinesStream(method string, params *url.Values, total int) (<-chan string, <-chan error) {
+	out := make(chan string)
+	errch := make(chan error, 1)
+
+	end := total
+	limited := ls.Count > 0 && ls.Offset+ls.Count <= end
+	if limited {
+		end = ls.Offset + ls.Count
+	}
+	nsegments := 0
+	if end > ls.Offset {
+		nsegments = (end - ls.Offset + lines_batch_size - 1) / lines_batch_size
+	}
+	window := 2 * ls.Workers
+
+	go func() {
+		defer close(out)
+		defer close(errch)
+
+		todo := make(chan lines_segment)
+		done := make(chan lines_segment)
+		stop := make(chan struct{})
+		defer close(stop)
+		for w := 0; w < ls.Workers; w++ {
+			go func() {
+				for seg := range todo {
+					count := lines_batch_size
+					if seg.offset+count > end {
+						count = end - seg.offset
+					}
+					last := seg.index == nsegments-1
+					seg.lines, seg.err = ls.fetchSegment(method, params, seg.offset, count, !last)
+					seg.full = len(seg.lines) == count
+					select {
+					case done <- seg:
+					case <-stop:
+						return
+					}
+				}
+			}()
+		}
+		defer close(todo)
+
+		dispatched := 0
+		emitted := 0
+		// Whether the lines after `end` may exist
+		more := !limited
+		pending := make(map[int]lines_segment)
+		for emitted < nsegments {
+			// Only dispatch a new segment if there is room in the window
+			var todo_ch chan lines_segment
+			if dispatched < nsegments && dispatched-emitted < window {
+				todo_ch = todo
+			}
+			next := lines_segment{index: dispatched, offset: ls.Offset + dispatched*lines_batch_size}
+			select {
+			case todo_ch <- next:
+				dispatched++
+			case seg := <-done:
+				if seg.err != nil {
+					errch <- seg.err
+					return
+				}
+				if seg.index == nsegments-1 {
+					more = more && seg.full
+				}
+				if ls.Unordered {
+					for _, line := range seg.lines {
+						out <- line
+					}
+					emitted++
+					continue
+				}
+				pending[seg.index] = seg
+				for {
+					seg, ok := pending[emitted]
+					if !ok {
+						break
+					}
+					delete(pending, emitted)
+					for _, line := range seg.lines {
+						out <- line
+					}
+					emitted++
+					if ls.Checkpoint != nil {
+						ls.Checkpoint(seg.offset + len(seg.lines))
+					}
+				}
+			}
+		}
+		if !more {
+			return
+		}
+
+		rest := LinesStream{Conn: ls.Conn, Offset: ls.Offset, Checkpoint: ls.Checkpoint}
+		if end > ls.Offset {
+			rest.Offset = end
+		}
+		if ls.Count > 0 {
+			rest.Count = ls.Offset + ls.Count - rest.Offset
+		}
+		if ls.Unordered {
+			rest.Checkpoint = nil
+		}
+		rest_params := url.Values{}
+		for k, v := range *params {
+			rest_params[k] = v
+		}
+		rest_lines, rest_errch := rest.asLinesStream(method, &rest_params)
+		for line := range rest_lines {
+			out <- line
+		}
+		for err := range rest_errch {
+			errch <- err
+			return
+		}
+	}()
+	return out, errch
+}
+
 // Make an API call to `method` and paramaeters `params` but using an
-// Scrapinghub job_id.
-func (ls *LinesStream) withJobID(method string, params *url.Values, job_id string) (<-chan string, <-chan error) {
+// Scrapinghub job_id. In parallel mode the total number of lines is taken
+// from the job info with `total` (sequential if nil).
+func (ls *LinesStream) withJobID(method string, params *url.Values, job_id string, total func(job *Job) int) (<-chan string, <-chan error) {
 	if err := ValidateJobID(job_id); err != nil {
 		return emptyStringChan(), fromErrToErrChan(err)
-	} else {
-		params.Set("job", job_id)
-		params.Set("project", ProjectID(job_id))
-		return ls.asLinesStream(method, params)
 	}
+	params.Set("job", job_id)
+	params.Set("project", ProjectID(job_id))
+	if ls.Workers > 1 && total != nil {
+		var jobs Jobs
+		job, err := jobs.JobInfo(ls.Conn, job_id)
+		if err != nil {
+			return emptyStringChan(), fromErrToErrChan(err)
+		}
+		return ls.asParallelLinesStream(method, params, total(job))
+	}
+	return ls.asLinesStream(method, params)
 }
 
 // Make an API call to `method` and paramaeters `params` but using an
@@ -122,7 +346,7 @@ func (ls *LinesStream) withProjectID(method string, params *url.Values, project_
 //  the JsonLines returned by the API items.jl endpoint.
 //  Returns a channel with errors
 func (ls *LinesStream) ItemsAsJsonLines(job_id string) (<-chan string, <-chan error) {
-	return ls.withJobID("items.jl", &url.Values{}, job_id)
+	return ls.withJobID("items.jl", &url.Values{}, job_id, jobItems)
 }
 
 //  Given a job_id, returns a channel of strings where each element is a line of
@@ -136,14 +360,26 @@ func (ls *LinesStream) ItemsAsCSV(job_id string, include_headers bool, fields st
 	params := url.Values{}
 	params.Add("include_headers", strconv.Itoa(iih))
 	params.Add("fields", fields)
-	return ls.withJobID("items.csv", &params, job_id)
+	// Every segment would have the headers, so it's retrieved sequentially
+	if include_headers {
+		return ls.withJobID("items.csv", &params, job_id, nil)
+	}
+	return ls.withJobID("items.csv", &params, job_id, jobItems)
 }
 
 // Returns a channel of strings which each element is a line of the log for job with `job_id`
 // Count and offset parameters are accepted to paginate results.
 //  Returns a channel with errors
 func (ls *LinesStream) LogLines(job_id string) (<-chan string, <-chan error) {
-	return ls.withJobID("log.txt", &url.Values{}, job_id)
+	return ls.withJobID("log.txt", &url.Values{}, job_id, jobLogs)
+}
+
+func jobItems(job *Job) int {
+	return job.ItemsScraped
+}
+
+func jobLogs(job *Job) int {
+	return job.Logs
 }
 
 // Returns a channel of strings which each element is a JSON serialized job for
@@ -0,0 +1,122 @@
+package scrapinghub
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Levels of the log records from the lowest to the highest
+var LogLevels = []string{"DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"}
+
+func logLevelIndex(level string) int {
+	for i, l := range LogLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// Conditions of the log records to select, the empty ones are not checked:
+// a minimum level, a logger (and its children, e.g: `scrapy.core` matches
+// `scrapy.core.scraper`), a regular expression searched in the lines of the
+// record and a minimum time. The log endpoint of the API has no parameters
+// for any of them, so the records are filtered on the client.
+type LogFilter struct {
+	Level  string
+	Logger string
+	Grep   *regexp.Regexp
+	Since  time.Time
+}
+
+// Returns the filter of the records of the level `level` or higher (e.g:
+// WARNING, case insensitive), of the logger `logger` and with lines matching
+// the regular expression `grep`, from the time `since` on (if not zero)
+func NewLogFilter(level, logger, grep string, since time.Time) (*LogFilter, error) {
+	f := &LogFilter{Level: strings.ToUpper(level), Logger: logger, Since: since}
+	if f.Level == "WARN" {
+		f.Level = "WARNING"
+	}
+	if f.Level != "" && logLevelIndex(f.Level) < 0 {
+		return nil, fmt.Errorf("NewLogFilter: wrong level %s, use one of %s", level, strings.Join(LogLevels, ", "))
+	}
+	if grep != "" {
+		re, err := regexp.Compile(grep)
+		if err != nil {
+			return nil, fmt.Errorf("NewLogFilter: wrong regular expression %s: %s", grep, err)
+		}
+		f.Grep = re
+	}
+	return f, nil
+}
+
+// Returns true if the filter has no conditions
+func (f *LogFilter) Empty() bool {
+	return f.Level == "" && f.Logger == "" && f.Grep == nil && f.Since.IsZero()
+}
+
+// Returns true if the record satisfies all the conditions of the filter. The
+// records without level or time (lines before the first record of the log)
+// don't satisfy the conditions of level or time.
+func (f *LogFilter) Match(rec *LogRecord) bool {
+	if f.Level != "" && logLevelIndex(rec.Level) < logLevelIndex(f.Level) {
+		return false
+	}
+	if f.Logger != "" && rec.Logger != f.Logger && !strings.HasPrefix(rec.Logger, f.Logger+".") {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if f.Grep != nil && !f.Grep.MatchString(rec.Raw) {
+		return false
+	}
+	return true
+}
+
+// Selects the records of a log matching `Filter` with up to `Context`
+// records before and after every match, like `grep -C`. The records are
+// given one by one in the order of the log.
+type LogContext struct {
+	Filter  *LogFilter
+	Context int
+	before  []*LogRecord
+	after   int
+	seen    int
+	printed int
+}
+
+// Adds the next record of the log, returns the records to output because of
+// it (the records of context before it and itself if it matches, or itself
+// if it's in the context after a match) and if there are records not output
+// between them and the previous ones
+func (c *LogContext) Feed(rec *LogRecord) ([]*LogRecord, bool) {
+	c.seen++
+	if c.Filter.Match(rec) {
+		out := append(c.before, rec)
+		c.before = nil
+		c.after = c.Context
+		return out, c.mark(len(out))
+	}
+	if c.after > 0 {
+		c.after--
+		return []*LogRecord{rec}, c.mark(1)
+	}
+	if c.Context > 0 {
+		if len(c.before) == c.Context {
+			c.before = c.before[1:]
+		}
+		c.before = append(c.before, rec)
+	}
+	return nil, false
+}
+
+// Records `n` records output up to the last one seen, returns true if there
+// are records not output before them
+func (c *LogContext) mark(n int) bool {
+	gap := c.printed > 0 && c.seen-n > c.printed
+	c.printed = c.seen
+	return gap
+}
@@ -0,0 +1,106 @@
+package scrapinghub
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A record of the log of a job: a line, or several for the records with a
+// traceback or a multi-line message
+type LogRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Logger  string    `json:"logger"`
+	Message string    `json:"message"`
+	Raw     string    `json:"raw"`
+}
+
+// Scrapy's default format: `2026-10-18 10:00:00 [scrapy.core.engine] INFO: Spider opened`
+var reScrapyLogLine = regexp.MustCompile(
+	`^(\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)\s+\[([^\]]+)\]\s+(DEBUG|INFO|WARNING|ERROR|CRITICAL):\s?(.*)$`)
+
+// The format with the level first: `2026-10-18 10:00:00 INFO [scrapy.core.engine] Spider opened`
+// (the logger is optional)
+var reLevelLogLine = regexp.MustCompile(
+	`^(\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)\s+(DEBUG|INFO|WARNING|ERROR|CRITICAL)\s+(?:\[([^\]]+)\]\s?)?(.*)$`)
+
+// Returns the record of `line` if it's the first line of a record (in
+// Scrapy's default format, or with the level before the logger). The time
+// is read as UTC.
+func ParseLogLine(line string) (*LogRecord, bool) {
+	var ts string
+	rec := &LogRecord{Raw: line}
+	if m := reScrapyLogLine.FindStringSubmatch(line); m != nil {
+		ts, rec.Logger, rec.Level, rec.Message = m[1], m[2], m[3], m[4]
+	} else if m := reLevelLogLine.FindStringSubmatch(line); m != nil {
+		ts, rec.Level, rec.Logger, rec.Message = m[1], m[2], m[3], m[4]
+	} else {
+		return nil, false
+	}
+	ts = strings.Replace(strings.Replace(ts, "T", " ", 1), ",", ".", 1)
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", ts, time.UTC)
+	if err != nil {
+		return nil, false
+	}
+	rec.Time = t
+	return rec, true
+}
+
+// Groups the lines of a log in records: the lines which don't start a
+// record (e.g: the lines of a traceback) are added to the record before
+// them. Lines before the first record are records without time, level and
+// logger.
+type LogParser struct {
+	pending *LogRecord
+}
+
+// Adds a line of the log, returns the previous record if `line` starts a new
+// one (it's complete then) or nil
+func (p *LogParser) Feed(line string) *LogRecord {
+	rec, ok := ParseLogLine(line)
+	if !ok {
+		if p.pending == nil {
+			p.pending = &LogRecord{Message: line, Raw: line}
+		} else {
+			p.pending.Message += "\n" + line
+			p.pending.Raw += "\n" + line
+		}
+		return nil
+	}
+	done := p.pending
+	p.pending = rec
+	return done
+}
+
+// Returns the last record, if any, at the end of the log
+func (p *LogParser) Flush() *LogRecord {
+	done := p.pending
+	p.pending = nil
+	return done
+}
+
+// Returns a channel with the records of the log of the job `job_id` (see
+// LogParser), using the count and offset of `ls` for the lines of the log.
+// The lines are always retrieved in order (Unordered is ignored), the lines
+// of a record must be consecutive. Returns a channel with errors
+func (ls *LinesStream) LogRecords(job_id string) (<-chan *LogRecord, <-chan error) {
+	ordered := *ls
+	ordered.Unordered = false
+	ch_lines, errch := ordered.LogLines(job_id)
+	out := make(chan *LogRecord)
+
+	go func() {
+		defer close(out)
+		var parser LogParser
+		for line := range ch_lines {
+			if rec := parser.Feed(line); rec != nil {
+				out <- rec
+			}
+		}
+		if rec := parser.Flush(); rec != nil {
+			out <- rec
+		}
+	}()
+	return out, errch
+}
@@ -0,0 +1,266 @@
+package scrapinghub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type projectSnapshot struct {
+	jobs    []Job
+	spiders []string
+}
+
+// The MetricsCollector polls the jobs and spiders of the projects
+// `Projects` and exposes their metrics, and the statistics of the calls to
+// the API done through `Conn`, in the Prometheus text format. `Count` limits
+// the number of (most recent) jobs listed for every project.
+type MetricsCollector struct {
+	Conn           *Connection
+	Projects       []string
+	Count          int
+	mu             sync.Mutex
+	snapshots      map[string]*projectSnapshot
+	collect_errors int
+	last_collect   time.Time
+}
+
+// Poll the API and keep the jobs and spiders of every project for the next
+// calls to WriteMetrics
+func (mc *MetricsCollector) Collect() error {
+	count := mc.Count
+	if count <= 0 {
+		count = 100
+	}
+	snapshots := make(map[string]*projectSnapshot)
+	var first_err error
+	for _, project_id := range mc.Projects {
+		var jobs Jobs
+		list, err := jobs.List(mc.Conn, project_id, count, nil)
+		if err != nil {
+			if first_err == nil {
+				first_err = fmt.Errorf("MetricsCollector: project %s: %s", project_id, err)
+			}
+			continue
+		}
+		snap := &projectSnapshot{jobs: list.Jobs}
+		var spiders Spiders
+		if slist, err := spiders.List(mc.Conn, project_id); err == nil {
+			for _, spider := range slist.Spiders {
+				snap.spiders = append(snap.spiders, spider["id"])
+			}
+		} else if first_err == nil {
+			first_err = fmt.Errorf("MetricsCollector: project %s: %s", project_id, err)
+		}
+		snapshots[project_id] = snap
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.snapshots == nil {
+		mc.snapshots = make(map[string]*projectSnapshot)
+	}
+	// Keep the previous data of the projects which failed
+	for project_id, snap := range snapshots {
+		mc.snapshots[project_id] = snap
+	}
+	if first_err != nil {
+		mc.collect_errors++
+	}
+	mc.last_collect = time.Now()
+	return first_err
+}
+
+// Escape a label value following the Prometheus text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type metricSample struct {
+	labels string
+	value  float64
+}
+
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	samples []metricSample
+}
+
+func (m *metric) add(value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escapeLabel(labels[i+1])))
+	}
+	m.samples = append(m.samples, metricSample{strings.Join(pairs, ","), value})
+}
+
+func (m *metric) write(w io.Writer) {
+	if len(m.samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+	for _, s := range m.samples {
+		if s.labels == "" {
+			fmt.Fprintf(w, "%s %s\n", m.name, formatValue(s.value))
+		} else {
+			fmt.Fprintf(w, "%s{%s} %s\n", m.name, s.labels, formatValue(s.value))
+		}
+	}
+}
+
+// Write the metrics of the last Collect in the Prometheus text format
+func (mc *MetricsCollector) WriteMetrics(out io.Writer) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	jobs_by_state := metric{name: "shub_jobs", kind: "gauge",
+		help: "Number of jobs listed by project, spider and state"}
+	last_items := metric{name: "shub_spider_last_job_items_scraped", kind: "gauge",
+		help: "Items scraped by the last finished job of the spider"}
+	last_errors := metric{name: "shub_spider_last_job_errors", kind: "gauge",
+		help: "Errors of the last finished job of the spider"}
+	last_logs := metric{name: "shub_spider_last_job_log_lines", kind: "gauge",
+		help: "Log lines of the last finished job of the spider"}
+	last_elapsed := metric{name: "shub_spider_last_job_elapsed", kind: "gauge",
+		help: "Elapsed value reported by the API for the last finished job of the spider"}
+	last_duration := metric{name: "shub_spider_last_job_duration_seconds", kind: "gauge",
+		help: "Duration of the last finished job of the spider"}
+	last_success := metric{name: "shub_spider_last_success_timestamp_seconds", kind: "gauge",
+		help: "Time when the last successful job of the spider finished"}
+	since_success := metric{name: "shub_spider_seconds_since_last_success", kind: "gauge",
+		help: "Seconds since the last successful job of the spider finished (+Inf if none in the jobs listed)"}
+
+	now := time.Now()
+	projects := make([]string, 0, len(mc.snapshots))
+	for project_id := range mc.snapshots {
+		projects = append(projects, project_id)
+	}
+	sort.Strings(projects)
+
+	for _, project_id := range projects {
+		snap := mc.snapshots[project_id]
+		counts := make(map[[2]string]int)
+		last := make(map[string]*Job)
+		success := make(map[string]time.Time)
+		spiders := make(map[string]bool)
+		for _, spider := range snap.spiders {
+			spiders[spider] = true
+		}
+		// The jobs are sorted from the most recent
+		for i := range snap.jobs {
+			job := &snap.jobs[i]
+			spiders[job.Spider] = true
+			counts[[2]string{job.Spider, job.State}]++
+			if job.State != "finished" {
+				continue
+			}
+			if _, ok := last[job.Spider]; !ok {
+				last[job.Spider] = job
+			}
+			if _, ok := success[job.Spider]; !ok && !job.Failed() {
+				if t, err := job.Updated(); err == nil {
+					success[job.Spider] = t
+				}
+			}
+		}
+
+		keys := make([][2]string, 0, len(counts))
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+		})
+		for _, k := range keys {
+			jobs_by_state.add(float64(counts[k]), "project", project_id, "spider", k[0], "state", k[1])
+		}
+
+		names := make([]string, 0, len(spiders))
+		for spider := range spiders {
+			names = append(names, spider)
+		}
+		sort.Strings(names)
+		for _, spider := range names {
+			labels := []string{"project", project_id, "spider", spider}
+			if job, ok := last[spider]; ok {
+				last_items.add(float64(job.ItemsScraped), labels...)
+				last_errors.add(float64(job.ErrorsCount), labels...)
+				last_logs.add(float64(job.Logs), labels...)
+				last_elapsed.add(float64(job.Elapsed), labels...)
+				started, err1 := job.Started()
+				updated, err2 := job.Updated()
+				if err1 == nil && err2 == nil {
+					last_duration.add(updated.Sub(started).Seconds(), labels...)
+				}
+			}
+			if t, ok := success[spider]; ok {
+				last_success.add(float64(t.Unix()), labels...)
+				since_success.add(now.Sub(t).Seconds(), labels...)
+			} else {
+				since_success.add(math.Inf(1), labels...)
+			}
+		}
+	}
+
+	api_calls := metric{name: "shub_api_calls_total", kind: "counter",
+		help: "Calls to the Scrapinghub API by method"}
+	api_errors := metric{name: "shub_api_call_errors_total", kind: "counter",
+		help: "Calls to the Scrapinghub API failed (connection error or HTTP status >= 400) by method"}
+	api_duration := metric{name: "shub_api_call_duration_seconds_total", kind: "counter",
+		help: "Total time waiting for the responses of the Scrapinghub API by method"}
+	stats := mc.Conn.Stats()
+	methods := make([]string, 0, len(stats))
+	for method := range stats {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		st := stats[method]
+		api_calls.add(float64(st.Calls), "method", method)
+		api_errors.add(float64(st.Errors), "method", method)
+		api_duration.add(st.Duration.Seconds(), "method", method)
+	}
+
+	collect_errors := metric{name: "shub_exporter_collect_errors_total", kind: "counter",
+		help: "Polls of the API by the exporter with some error"}
+	collect_errors.add(float64(mc.collect_errors))
+	last_collect := metric{name: "shub_exporter_last_collect_timestamp_seconds", kind: "gauge",
+		help: "Time of the last poll of the API by the exporter"}
+	if !mc.last_collect.IsZero() {
+		last_collect.add(float64(mc.last_collect.Unix()))
+	}
+
+	w := bufio.NewWriter(out)
+	for _, m := range []*metric{&jobs_by_state, &last_items, &last_errors, &last_logs, &last_elapsed,
+		&last_duration, &last_success, &since_success, &api_calls, &api_errors, &api_duration,
+		&collect_errors, &last_collect} {
+		m.write(w)
+	}
+	return w.Flush()
+}
+
+// Serve the metrics through HTTP
+func (mc *MetricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mc.WriteMetrics(w)
+}
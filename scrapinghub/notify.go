@@ -0,0 +1,247 @@
+package scrapinghub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A Notifier sends a notification about a job event somewhere (a webhook,
+// a command, an email, ...)
+type Notifier interface {
+	Notify(ev *JobEvent) error
+}
+
+// Default template for the webhook JSON payload, compatible with Slack
+// incoming webhooks
+const DEFAULT_WEBHOOK_TEMPLATE = `{"text": {{printf "Job %s (spider %s) %s. Items: %d, errors: %d, close reason: %s" .Job.Id .Job.Spider .Type .Job.ItemsScraped .Job.ErrorsCount .Job.CloseReason | json}}}`
+
+var template_funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Maximum time to post a webhook, so a webhook not responding doesn't
+// block the notifications
+const WEBHOOK_TIMEOUT = 30 * time.Second
+
+var webhook_client = &http.Client{Timeout: WEBHOOK_TIMEOUT}
+
+// Post a JSON payload to `URL` for every event. The payload is built with
+// the text/template `Template` (DEFAULT_WEBHOOK_TEMPLATE if empty) executed
+// with the JobEvent; the function `json` is available to encode values.
+type WebhookNotifier struct {
+	URL      string
+	Template string
+	tmpl     *template.Template
+}
+
+// Parse the template of the payload, LoadNotifiers does it when the
+// notifier is loaded
+func (wn *WebhookNotifier) parseTemplate() error {
+	text := wn.Template
+	if text == "" {
+		text = DEFAULT_WEBHOOK_TEMPLATE
+	}
+	tmpl, err := template.New("webhook").Funcs(template_funcs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("WebhookNotifier: wrong template: %s", err)
+	}
+	wn.tmpl = tmpl
+	return nil
+}
+
+func (wn *WebhookNotifier) Notify(ev *JobEvent) error {
+	if wn.tmpl == nil {
+		if err := wn.parseTemplate(); err != nil {
+			return err
+		}
+	}
+	var body bytes.Buffer
+	if err := wn.tmpl.Execute(&body, ev); err != nil {
+		return fmt.Errorf("WebhookNotifier: %s", err)
+	}
+	resp, err := webhook_client.Post(wn.URL, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("WebhookNotifier: %s returned %s", wn.URL, resp.Status)
+	}
+	return nil
+}
+
+// Run the shell command `Command` for every event, with the fields of the
+// job in environment variables: SHUBC_EVENT, SHUBC_JOB_ID, SHUBC_PROJECT,
+// SHUBC_SPIDER, SHUBC_STATE, SHUBC_CLOSE_REASON, SHUBC_ITEMS, SHUBC_ERRORS,
+// SHUBC_LOGS and SHUBC_JOB (the whole job as JSON).
+type CommandNotifier struct {
+	Command string
+}
+
+func (cn *CommandNotifier) Notify(ev *JobEvent) error {
+	job_json, err := json.Marshal(ev.Job)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", cn.Command)
+	cmd.Env = append(os.Environ(),
+		"SHUBC_EVENT="+string(ev.Type),
+		"SHUBC_JOB_ID="+ev.Job.Id,
+		"SHUBC_PROJECT="+ProjectID(ev.Job.Id),
+		"SHUBC_SPIDER="+ev.Job.Spider,
+		"SHUBC_STATE="+ev.Job.State,
+		"SHUBC_CLOSE_REASON="+ev.Job.CloseReason,
+		"SHUBC_ITEMS="+strconv.Itoa(ev.Job.ItemsScraped),
+		"SHUBC_ERRORS="+strconv.Itoa(ev.Job.ErrorsCount),
+		"SHUBC_LOGS="+strconv.Itoa(ev.Job.Logs),
+		"SHUBC_JOB="+string(job_json),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("CommandNotifier: '%s' failed: %s: %s", cn.Command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Send an email for every event through the SMTP server `Addr` (host:port).
+// If `Username` is given, PLAIN authentication is used.
+type EmailNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (en *EmailNotifier) Notify(ev *JobEvent) error {
+	var auth smtp.Auth
+	if en.Username != "" {
+		host := strings.Split(en.Addr, ":")[0]
+		auth = smtp.PlainAuth("", en.Username, en.Password, host)
+	}
+	job := &ev.Job
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", en.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(en.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [shubc] Job %s (spider %s) %s\r\n", job.Id, job.Spider, ev.Type)
+	fmt.Fprintf(&msg, "\r\n")
+	fmt.Fprintf(&msg, "Job: %s\r\nSpider: %s\r\nEvent: %s\r\nState: %s\r\nClose reason: %s\r\n",
+		job.Id, job.Spider, ev.Type, job.State, job.CloseReason)
+	fmt.Fprintf(&msg, "Items: %d\r\nErrors: %d\r\nLog lines: %d\r\nStarted: %s\r\nUpdated: %s\r\n",
+		job.ItemsScraped, job.ErrorsCount, job.Logs, job.StartedTime, job.UpdatedTime)
+	return smtp.SendMail(en.Addr, auth, en.From, en.To, msg.Bytes())
+}
+
+type notifiersFile struct {
+	Webhook []*WebhookNotifier
+	Command []*CommandNotifier
+	Email   []*EmailNotifier
+}
+
+// Load the notifiers declared in the file (TOML format) in `path`, e.g:
+//
+//	[[webhook]]
+//	url = "https://hooks.slack.com/services/..."
+//
+//	[[command]]
+//	command = "echo $SHUBC_JOB_ID $SHUBC_EVENT >> events.log"
+//
+//	[[email]]
+//	addr = "smtp.example.com:587"
+//	username = "shubc"
+//	password = "secret"
+//	from = "shubc@example.com"
+//	to = ["oncall@example.com"]
+func LoadNotifiers(path string) ([]Notifier, error) {
+	var nf notifiersFile
+	if _, err := toml.DecodeFile(path, &nf); err != nil {
+		return nil, fmt.Errorf("LoadNotifiers: can't parse file %s: %s", path, err)
+	}
+	var notifiers []Notifier
+	for _, n := range nf.Webhook {
+		if n.URL == "" {
+			return nil, fmt.Errorf("LoadNotifiers: webhook without url")
+		}
+		if err := n.parseTemplate(); err != nil {
+			return nil, fmt.Errorf("LoadNotifiers: webhook %s: %s", n.URL, err)
+		}
+		notifiers = append(notifiers, n)
+	}
+	for _, n := range nf.Command {
+		if n.Command == "" {
+			return nil, fmt.Errorf("LoadNotifiers: command without command")
+		}
+		notifiers = append(notifiers, n)
+	}
+	for _, n := range nf.Email {
+		if n.Addr == "" || n.From == "" || len(n.To) == 0 {
+			return nil, fmt.Errorf("LoadNotifiers: email requires addr, from and to")
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// Number of events notified remembered by the NotifyDispatcher, the oldest
+// ones are forgotten
+const NOTIFIED_MAX = 10000
+
+// The NotifyDispatcher sends the events of the types in `On` (all of them
+// if empty) to every notifier in `Notifiers`. Every job notifies only once
+// of each type of event (among the last NOTIFIED_MAX events notified).
+type NotifyDispatcher struct {
+	Notifiers []Notifier
+	On        []JobEventType
+	notified  map[string]bool
+	order     []string
+}
+
+// Send the event `ev` to the notifiers if needed. Returns true if the event
+// was sent and the errors of the notifiers that failed.
+func (nd *NotifyDispatcher) Dispatch(ev *JobEvent) (bool, []error) {
+	if len(nd.On) > 0 {
+		wanted := false
+		for _, t := range nd.On {
+			if t == ev.Type {
+				wanted = true
+			}
+		}
+		if !wanted {
+			return false, nil
+		}
+	}
+	if nd.notified == nil {
+		nd.notified = make(map[string]bool)
+	}
+	key := ev.Job.Id + " " + string(ev.Type)
+	if nd.notified[key] {
+		return false, nil
+	}
+	nd.notified[key] = true
+	nd.order = append(nd.order, key)
+	if len(nd.order) > NOTIFIED_MAX {
+		delete(nd.notified, nd.order[0])
+		nd.order = nd.order[1:]
+	}
+
+	var errs []error
+	for _, n := range nd.Notifiers {
+		if err := n.Notify(ev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return true, errs
+}
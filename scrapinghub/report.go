@@ -0,0 +1,148 @@
+package scrapinghub
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Statistics of the jobs of a spider in a period of time
+type SpiderPeriodStats struct {
+	Runs         int            `json:"runs"`
+	Finished     int            `json:"finished"`
+	Succeeded    int            `json:"succeeded"`
+	CloseReasons map[string]int `json:"close_reasons"`
+	// Percentage of the jobs finished with close reason "finished"
+	SuccessRate float64 `json:"success_rate"`
+	// Median and 95th percentile of the duration of the jobs (seconds)
+	MedianDuration float64 `json:"median_duration"`
+	P95Duration    float64 `json:"p95_duration"`
+	// Averages of the jobs finished, the running ones are not complete yet
+	ItemsPerRun  float64 `json:"items_per_run"`
+	ErrorsPerRun float64 `json:"errors_per_run"`
+}
+
+// Report of the jobs of a spider: the statistics of the period of the
+// report and of the previous one, and the problems found
+type SpiderReport struct {
+	Spider   string            `json:"spider"`
+	Current  SpiderPeriodStats `json:"current"`
+	Previous SpiderPeriodStats `json:"previous"`
+	LastRun  time.Time         `json:"last_run"`
+	// Percentage change of the items per run from the previous period (0 if
+	// there were no runs in any of the periods)
+	ItemsTrend float64 `json:"items_trend"`
+	// The spider didn't run in the period of the report
+	NotRun bool `json:"not_run"`
+	// The items per run dropped more than the threshold of the report
+	ItemsDropped bool `json:"items_dropped"`
+}
+
+// Report of the health of a project in the period from `Since` to `Until`
+type ProjectReport struct {
+	Project string         `json:"project"`
+	Since   time.Time      `json:"since"`
+	Until   time.Time      `json:"until"`
+	Spiders []SpiderReport `json:"spiders"`
+}
+
+// Returns the value of the percentile `p` (0-100) of the sorted values
+// using the nearest-rank method
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100.0 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func periodStats(jobs []*Job) SpiderPeriodStats {
+	stats := SpiderPeriodStats{Runs: len(jobs), CloseReasons: make(map[string]int)}
+	if len(jobs) == 0 {
+		return stats
+	}
+	var durations []float64
+	items := 0
+	errors := 0
+	for _, job := range jobs {
+		if job.State != "finished" {
+			continue
+		}
+		stats.Finished++
+		items += job.ItemsScraped
+		errors += job.ErrorsCount
+		stats.CloseReasons[job.CloseReason]++
+		if !job.Failed() {
+			stats.Succeeded++
+		}
+		started, err1 := job.Started()
+		updated, err2 := job.Updated()
+		if err1 == nil && err2 == nil {
+			durations = append(durations, updated.Sub(started).Seconds())
+		}
+	}
+	sort.Float64s(durations)
+	if stats.Finished > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) * 100.0 / float64(stats.Finished)
+		stats.ItemsPerRun = float64(items) / float64(stats.Finished)
+		stats.ErrorsPerRun = float64(errors) / float64(stats.Finished)
+	}
+	stats.MedianDuration = percentile(durations, 50)
+	stats.P95Duration = percentile(durations, 95)
+	return stats
+}
+
+// Build the report of the project `project_id` for the period of length
+// `period` ending at `until`, given its `jobs` (from `until - 2 * period`
+// to compare with the previous period) and `spiders` (to find the spiders
+// which didn't run). Spiders whose items per run dropped more than
+// `drop_threshold` percent from the previous period are flagged.
+func BuildReport(project_id string, jobs []Job, spiders []string, until time.Time, period time.Duration, drop_threshold float64) *ProjectReport {
+	since := until.Add(-period)
+	prev_since := since.Add(-period)
+
+	current := make(map[string][]*Job)
+	previous := make(map[string][]*Job)
+	last_run := make(map[string]time.Time)
+	names := make(map[string]bool)
+	for _, spider := range spiders {
+		names[spider] = true
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		started, err := job.Started()
+		if err != nil || !started.Before(until) || started.Before(prev_since) {
+			continue
+		}
+		names[job.Spider] = true
+		if started.After(last_run[job.Spider]) {
+			last_run[job.Spider] = started
+		}
+		if started.Before(since) {
+			previous[job.Spider] = append(previous[job.Spider], job)
+		} else {
+			current[job.Spider] = append(current[job.Spider], job)
+		}
+	}
+
+	report := ProjectReport{Project: project_id, Since: since, Until: until}
+	for spider := range names {
+		sr := SpiderReport{
+			Spider:   spider,
+			Current:  periodStats(current[spider]),
+			Previous: periodStats(previous[spider]),
+			LastRun:  last_run[spider],
+		}
+		sr.NotRun = sr.Current.Runs == 0
+		if sr.Previous.ItemsPerRun > 0 && sr.Current.Finished > 0 {
+			sr.ItemsTrend = (sr.Current.ItemsPerRun - sr.Previous.ItemsPerRun) * 100.0 / sr.Previous.ItemsPerRun
+			sr.ItemsDropped = sr.ItemsTrend < -drop_threshold
+		}
+		report.Spiders = append(report.Spiders, sr)
+	}
+	sort.Slice(report.Spiders, func(i, j int) bool { return report.Spiders[i].Spider < report.Spiders[j].Spider })
+	return &report
+}
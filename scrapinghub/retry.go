@@ -0,0 +1,131 @@
+package scrapinghub
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Tags used to track the retries of the failed jobs: the new job is tagged
+// with "retry:N" (N is the number of the attempt) and the failed job with
+// "retried", so it's not retried twice.
+const (
+	RETRY_TAG_PREFIX = "retry:"
+	RETRIED_TAG      = "retried"
+)
+
+// Default close reasons of the jobs to retry
+var DefaultRetryCloseReasons = []string{"failed", "memusage_exceeded", "shutdown"}
+
+// Policy to decide which failed jobs are retried by Jobs.RetryFailed
+type RetryPolicy struct {
+	// Close reasons of the jobs to retry (DefaultRetryCloseReasons if empty)
+	CloseReasons []string
+	// If > 0, jobs finished with more errors than MaxErrors are retried too
+	MaxErrors int
+	// Maximum number of retries for a spider, counting the retries of the
+	// previous failed jobs (the "retry:N" tag)
+	MaxAttempts int
+	// Number of recent jobs of the project to scan
+	Count int
+}
+
+// Represent the result of retrying a failed job: the new job id or the
+// reason why it was not retried. TagErr is the error tagging the failed job
+// as "retried" once the new job was scheduled (NewJobId is set then).
+type RetryResult struct {
+	Job      Job
+	Attempt  int
+	NewJobId string
+	Skipped  string
+	Err      error
+	TagErr   error
+}
+
+// Returns the attempt number of a job given its tags (0 for a job which is
+// not a retry)
+func retryAttempt(tags []string) int {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, RETRY_TAG_PREFIX) {
+			n, err := strconv.Atoi(tag[len(RETRY_TAG_PREFIX):])
+			if err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+func (policy *RetryPolicy) matches(job *Job) bool {
+	if job.State != "finished" {
+		return false
+	}
+	reasons := policy.CloseReasons
+	if len(reasons) == 0 {
+		reasons = DefaultRetryCloseReasons
+	}
+	for _, reason := range reasons {
+		if job.CloseReason == reason {
+			return true
+		}
+	}
+	return policy.MaxErrors > 0 && job.ErrorsCount > policy.MaxErrors
+}
+
+// Scan the recent jobs of `project_id` and reschedule (like Reschedule does)
+// the last job of every spider if it failed according to `policy`. The new
+// job is tagged with "retry:N" and the failed one with "retried". Spiders
+// with a job pending or running are not retried.
+func (jobs *Jobs) RetryFailed(conn *Connection, project_id string, policy *RetryPolicy) ([]RetryResult, error) {
+	if err := ValidateProjectID(project_id); err != nil {
+		return nil, err
+	}
+	count := policy.Count
+	if count <= 0 {
+		count = 100
+	}
+	var list Jobs
+	if _, err := list.List(conn, project_id, count, nil); err != nil {
+		return nil, err
+	}
+
+	var results []RetryResult
+	seen := make(map[string]bool)
+	// The jobs are sorted from the most recent
+	for _, job := range list.Jobs {
+		if seen[job.Spider] {
+			continue
+		}
+		seen[job.Spider] = true
+		if !policy.matches(&job) {
+			continue
+		}
+
+		result := RetryResult{Job: job, Attempt: retryAttempt(job.Tags) + 1}
+		for _, tag := range job.Tags {
+			if tag == RETRIED_TAG {
+				result.Skipped = "already retried"
+			}
+		}
+		if result.Skipped == "" && policy.MaxAttempts > 0 && result.Attempt > policy.MaxAttempts {
+			result.Skipped = "max attempts reached"
+		}
+		if result.Skipped != "" {
+			results = append(results, result)
+			continue
+		}
+
+		var tags []string
+		for _, tag := range job.Tags {
+			if !strings.HasPrefix(tag, RETRY_TAG_PREFIX) {
+				tags = append(tags, tag)
+			}
+		}
+		tags = append(tags, RETRY_TAG_PREFIX+strconv.Itoa(result.Attempt))
+		result.NewJobId, result.Err = jobs.rescheduleJob(conn, &job, tags)
+		if result.Err == nil {
+			result.TagErr = jobs.Update(conn, job.Id, map[string]string{"add_tag": RETRIED_TAG})
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
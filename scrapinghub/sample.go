@@ -0,0 +1,89 @@
+package scrapinghub
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Returns `n` offsets of a job with `total` items (all of them if n >= total),
+// sorted: random ones, or spread evenly over the job
+func SampleOffsets(total, n int, random bool, rnd *rand.Rand) []int {
+	if n >= total {
+		n = total
+	}
+	if n <= 0 {
+		return nil
+	}
+	offsets := make([]int, 0, n)
+	if !random {
+		for i := 0; i < n; i++ {
+			offsets = append(offsets, i*total/n)
+		}
+		return offsets
+	}
+	// Floyd's algorithm: n distinct offsets without building all of them
+	chosen := make(map[int]bool, n)
+	for j := total - n; j < total; j++ {
+		t := rnd.Intn(j + 1)
+		if chosen[t] {
+			t = j
+		}
+		chosen[t] = true
+		offsets = append(offsets, t)
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// Returns a sample of `n` items of the job `job_id` (see SampleOffsets),
+// retrieving only those items, with `workers` requests at the same time.
+// Consecutive offsets are retrieved in a single request.
+func SampleItems(conn *Connection, job_id string, n int, random bool, workers int) ([]ItemResult[map[string]interface{}], error) {
+	var jobs Jobs
+	job, err := jobs.JobInfo(conn, job_id)
+	if err != nil {
+		return nil, err
+	}
+	offsets := SampleOffsets(job.ItemsScraped, n, random, rand.New(rand.NewSource(rand.Int63())))
+
+	// Ranges [start, end) of consecutive offsets
+	var ranges [][2]int
+	for _, offset := range offsets {
+		if len(ranges) > 0 && ranges[len(ranges)-1][1] == offset {
+			ranges[len(ranges)-1][1]++
+			continue
+		}
+		ranges = append(ranges, [2]int{offset, offset + 1})
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([][]map[string]interface{}, len(ranges))
+	errs := make([]error, len(ranges))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i, start, end int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = RetrieveItems(conn, job_id, end-start, start)
+		}(i, r[0], r[1])
+	}
+	wg.Wait()
+
+	var sample []ItemResult[map[string]interface{}]
+	for i, r := range ranges {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("SampleItems: items at offset %d of job %s: %s", r[0], job_id, errs[i])
+		}
+		for j, item := range results[i] {
+			sample = append(sample, ItemResult[map[string]interface{}]{Item: item, Offset: r[0] + j})
+		}
+	}
+	return sample, nil
+}
@@ -0,0 +1,272 @@
+package scrapinghub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Represent an entry of a schedule file: a spider to run periodically
+// following a cron expression
+type ScheduleEntry struct {
+	Name          string
+	Cron          string
+	Project       string
+	Spider        string
+	Args          map[string]string
+	Tags          []string
+	Priority      *int
+	SkipIfRunning bool `toml:"skip_if_running"`
+	expr          *CronExpr
+}
+
+// Returns the time of the next run of the entry after `t`
+func (entry *ScheduleEntry) Next(t time.Time) time.Time {
+	return entry.expr.Next(t)
+}
+
+type scheduleFile struct {
+	Job []ScheduleEntry
+}
+
+// Load the schedule file (TOML format) in `path`. Every job is declared in
+// a [[job]] table, e.g:
+//
+//	[[job]]
+//	name = "books-daily"
+//	cron = "0 3 * * *"
+//	project = "123"
+//	spider = "books"
+//	tags = ["daily"]
+//	priority = 3
+//	skip_if_running = true
+//	[job.args]
+//	category = "fiction"
+func LoadSchedule(path string) ([]ScheduleEntry, error) {
+	var sf scheduleFile
+	if _, err := toml.DecodeFile(path, &sf); err != nil {
+		return nil, fmt.Errorf("LoadSchedule: can't parse schedule file %s: %s", path, err)
+	}
+	names := make(map[string]bool)
+	for i := range sf.Job {
+		entry := &sf.Job[i]
+		if entry.Name == "" {
+			entry.Name = entry.Spider
+		}
+		if entry.Spider == "" {
+			return nil, fmt.Errorf("LoadSchedule: job #%d has no spider", i+1)
+		}
+		if err := ValidateProjectID(entry.Project); err != nil {
+			return nil, fmt.Errorf("LoadSchedule: job '%s': %s", entry.Name, err)
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("LoadSchedule: duplicated job name '%s'", entry.Name)
+		}
+		names[entry.Name] = true
+		expr, err := ParseCron(entry.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("LoadSchedule: job '%s': %s", entry.Name, err)
+		}
+		entry.expr = expr
+	}
+	return sf.Job, nil
+}
+
+// Represent a run of a schedule entry at some time (planned or already done)
+type ScheduleRun struct {
+	Entry   *ScheduleEntry
+	Time    time.Time
+	JobId   string
+	Skipped bool
+	Err     error
+}
+
+type scheduleState struct {
+	LastRun time.Time `json:"last_run"`
+	LastJob string    `json:"last_job,omitempty"`
+}
+
+// The Scheduler runs the spiders of a schedule following its cron
+// expressions. The time of the last run of every entry is persisted in the
+// file `StatePath` (if given), so a restarted scheduler doesn't run twice
+// the same entry neither miss a run which was due while it was stopped.
+type Scheduler struct {
+	Conn      *Connection
+	Entries   []ScheduleEntry
+	StatePath string
+	state     map[string]*scheduleState
+}
+
+func (s *Scheduler) loadState(now time.Time) error {
+	s.state = make(map[string]*scheduleState)
+	if s.StatePath != "" {
+		content, err := ioutil.ReadFile(s.StatePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(content, &s.state); err != nil {
+				return fmt.Errorf("Scheduler: can't read state file %s: %s", s.StatePath, err)
+			}
+		}
+	}
+	// Entries never run start counting from now
+	for _, entry := range s.Entries {
+		if _, ok := s.state[entry.Name]; !ok {
+			s.state[entry.Name] = &scheduleState{LastRun: now}
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) saveState() error {
+	if s.StatePath == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.StatePath, content)
+}
+
+// Returns the next `n` runs of every entry after `from`, sorted by time
+func (s *Scheduler) Plan(from time.Time, n int) []ScheduleRun {
+	var plan []ScheduleRun
+	for i := range s.Entries {
+		entry := &s.Entries[i]
+		t := from
+		for j := 0; j < n; j++ {
+			t = entry.Next(t)
+			if t.IsZero() {
+				break
+			}
+			plan = append(plan, ScheduleRun{Entry: entry, Time: t})
+		}
+	}
+	sort.SliceStable(plan, func(i, j int) bool { return plan[i].Time.Before(plan[j].Time) })
+	return plan
+}
+
+// Returns true if the spider of the entry has a pending or running job
+func (s *Scheduler) isRunning(entry *ScheduleEntry) (bool, error) {
+	for _, state := range []string{"pending", "running"} {
+		var jobs Jobs
+		filters := map[string]string{"spider": entry.Spider, "state": state}
+		list, err := jobs.List(s.Conn, entry.Project, 1, filters)
+		if err != nil {
+			return false, err
+		}
+		if len(list.Jobs) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Schedule the job of the entry unless it must be skipped
+func (s *Scheduler) runEntry(entry *ScheduleEntry, now time.Time) ScheduleRun {
+	run := ScheduleRun{Entry: entry, Time: now}
+	if entry.SkipIfRunning {
+		running, err := s.isRunning(entry)
+		if err != nil {
+			run.Err = err
+			return run
+		}
+		if running {
+			run.Skipped = true
+			return run
+		}
+	}
+	args := make(map[string]string)
+	for k, v := range entry.Args {
+		args[k] = v
+	}
+	if entry.Priority != nil {
+		args["priority"] = strconv.Itoa(*entry.Priority)
+	}
+	var jobs Jobs
+	run.JobId, run.Err = jobs.Schedule(s.Conn, entry.Project, entry.Spider, args, entry.Tags...)
+	return run
+}
+
+// Run the entries due at `now` and returns the result of every run
+func (s *Scheduler) RunPending(now time.Time) ([]ScheduleRun, error) {
+	if s.state == nil {
+		if err := s.loadState(now); err != nil {
+			return nil, err
+		}
+	}
+	var runs []ScheduleRun
+	for i := range s.Entries {
+		entry := &s.Entries[i]
+		st := s.state[entry.Name]
+		next := entry.Next(st.LastRun)
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+		run := s.runEntry(entry, now)
+		runs = append(runs, run)
+		// Failed runs are retried on the next check
+		if run.Err == nil {
+			st.LastRun = now
+			if run.JobId != "" {
+				st.LastJob = run.JobId
+			}
+		}
+	}
+	if len(runs) > 0 {
+		if err := s.saveState(); err != nil {
+			return runs, err
+		}
+	}
+	return runs, nil
+}
+
+// Run the scheduler until `stop` is closed, checking every minute for entries
+// to run. Returns a channel with the runs done and a channel of errors, the
+// scheduler stops on the first error.
+func (s *Scheduler) Run(stop <-chan struct{}) (<-chan ScheduleRun, <-chan error) {
+	out := make(chan ScheduleRun)
+	errch := make(chan error, 1)
+
+	go func() {
+		defer close(errch)
+		defer close(out)
+
+		if err := s.loadState(time.Now()); err != nil {
+			errch <- err
+			return
+		}
+		for {
+			runs, err := s.RunPending(time.Now())
+			for _, run := range runs {
+				select {
+				case out <- run:
+				case <-stop:
+					return
+				}
+			}
+			if err != nil {
+				errch <- err
+				return
+			}
+			// Wake up at the beginning of the next minute
+			now := time.Now()
+			timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return out, errch
+}
@@ -0,0 +1,266 @@
+package scrapinghub
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// Number of distinct sample values kept for every field
+const schemaSamples = 3
+
+// Statistics of a field of the items. The path of nested fields is joined
+// with dots and the elements of lists are `[]` (e.g: offers[].price).
+type FieldStats struct {
+	Path string `json:"path"`
+	// Number of values of every type: string, integer, number, boolean,
+	// object, array or null
+	Types map[string]int `json:"types"`
+	// Number of items with a value (not null) in the field
+	Filled int `json:"filled"`
+	// Percentage of items with a value in the field
+	FillRate float64 `json:"fill_rate"`
+	// Estimate of the number of distinct values, exact if CardinalityExact
+	Cardinality      uint64 `json:"cardinality"`
+	CardinalityExact bool   `json:"cardinality_exact"`
+	// Min and max length of the strings (in characters) and lists, -1 if
+	// there are none in the field
+	MinLength int      `json:"min_length"`
+	MaxLength int      `json:"max_length"`
+	Samples   []string `json:"samples"`
+	hll       *HyperLogLog
+	last_item int
+}
+
+type schemaNode struct {
+	stats *FieldStats
+	// Objects seen in the field, and how many of them had every key
+	objects  int
+	keys     []string
+	children map[string]*schemaNode
+	present  map[string]int
+	elem     *schemaNode
+}
+
+func newSchemaNode(path string) *schemaNode {
+	return &schemaNode{
+		stats: &FieldStats{Path: path, Types: make(map[string]int), MinLength: -1, MaxLength: -1,
+			hll: NewHyperLogLog(), last_item: -1},
+		children: make(map[string]*schemaNode),
+		present:  make(map[string]int),
+	}
+}
+
+// Infer the schema of the items and the statistics of their fields, adding
+// the items one by one (the memory used depends on the number of fields, not
+// on the number of items), e.g:
+//
+//	s := NewItemSchema()
+//	for item := range items {
+//		s.Add(item)
+//	}
+//	for _, f := range s.Fields() {
+//		fmt.Println(f.Path, f.FillRate, f.Cardinality)
+//	}
+type ItemSchema struct {
+	Items int
+	root  *schemaNode
+	paths []*schemaNode
+}
+
+func NewItemSchema() *ItemSchema {
+	return &ItemSchema{root: newSchemaNode("")}
+}
+
+// Returns the JSON type of a value decoded from JSON (with or without UseNumber)
+func jsonType(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			return "integer"
+		}
+		// Numbers without fractional part are integers too, e.g: 1.0 or 1e20
+		if f, err := val.Float64(); err == nil && isWhole(f) {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if isWhole(val) {
+			return "integer"
+		}
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "string"
+}
+
+// Returns true if the number has no fractional part
+func isWhole(f float64) bool {
+	return !math.IsInf(f, 0) && math.Trunc(f) == f
+}
+
+func (s *ItemSchema) Add(item map[string]interface{}) {
+	s.add(s.root, item)
+	s.Items++
+}
+
+func (s *ItemSchema) child(node *schemaNode, key string) *schemaNode {
+	child, ok := node.children[key]
+	if !ok {
+		path := key
+		if node != s.root {
+			path = node.stats.Path + "." + key
+		}
+		child = newSchemaNode(path)
+		node.children[key] = child
+		node.keys = append(node.keys, key)
+		s.paths = append(s.paths, child)
+	}
+	return child
+}
+
+func (s *ItemSchema) add(node *schemaNode, v interface{}) {
+	st := node.stats
+	t := jsonType(v)
+	st.Types[t]++
+	if t != "null" && st.last_item != s.Items {
+		st.last_item = s.Items
+		st.Filled++
+	}
+
+	length := -1
+	switch val := v.(type) {
+	case map[string]interface{}:
+		node.objects++
+		for k, cv := range val {
+			node.present[k]++
+			s.add(s.child(node, k), cv)
+		}
+	case []interface{}:
+		length = len(val)
+		if node.elem == nil {
+			node.elem = newSchemaNode(st.Path + "[]")
+			s.paths = append(s.paths, node.elem)
+		}
+		for _, ev := range val {
+			s.add(node.elem, ev)
+		}
+	case string:
+		length = utf8.RuneCountInString(val)
+	}
+	if length >= 0 {
+		if st.MinLength < 0 || length < st.MinLength {
+			st.MinLength = length
+		}
+		if length > st.MaxLength {
+			st.MaxLength = length
+		}
+	}
+
+	if node == s.root || t == "null" {
+		return
+	}
+	var text string
+	if str, ok := v.(string); ok {
+		text = str
+	} else {
+		b, _ := json.Marshal(v)
+		text = string(b)
+	}
+	st.hll.Add(text)
+	if len(st.Samples) < schemaSamples && t != "object" && t != "array" {
+		for _, sample := range st.Samples {
+			if sample == text {
+				return
+			}
+		}
+		st.Samples = append(st.Samples, text)
+	}
+}
+
+// Returns the statistics of all the fields sorted by path
+func (s *ItemSchema) Fields() []*FieldStats {
+	fields := make([]*FieldStats, 0, len(s.paths))
+	for _, node := range s.paths {
+		st := node.stats
+		if s.Items > 0 {
+			st.FillRate = 100 * float64(st.Filled) / float64(s.Items)
+		}
+		st.Cardinality = st.hll.Count()
+		st.CardinalityExact = false
+		// Few distinct values are counted exactly in the samples
+		if n := uint64(len(st.Samples)); n < schemaSamples && st.Types["object"]+st.Types["array"] == 0 {
+			st.Cardinality = n
+			st.CardinalityExact = true
+		}
+		fields = append(fields, st)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return fields
+}
+
+// Returns the JSON Schema (draft-07) of the items: the types seen in every
+// field, the properties of the objects (required if they were in all the
+// objects) and the items of the lists
+func (s *ItemSchema) JSONSchema() map[string]interface{} {
+	schema := s.jsonSchema(s.root)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["type"] = "object"
+	return schema
+}
+
+func (s *ItemSchema) jsonSchema(node *schemaNode) map[string]interface{} {
+	schema := map[string]interface{}{}
+	var types []string
+	for t := range node.stats.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	// integer is a subset of number
+	if node.stats.Types["integer"] > 0 && node.stats.Types["number"] > 0 {
+		for i, t := range types {
+			if t == "integer" {
+				types = append(types[:i], types[i+1:]...)
+				break
+			}
+		}
+	}
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+
+	if node.objects > 0 {
+		keys := append([]string(nil), node.keys...)
+		sort.Strings(keys)
+		properties := map[string]interface{}{}
+		var required []string
+		for _, k := range keys {
+			properties[k] = s.jsonSchema(node.children[k])
+			if node.present[k] == node.objects {
+				required = append(required, k)
+			}
+		}
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	if node.elem != nil {
+		schema["items"] = s.jsonSchema(node.elem)
+	}
+	return schema
+}
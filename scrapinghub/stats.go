@@ -0,0 +1,155 @@
+package scrapinghub
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Number of values of every field kept to compute the percentiles and the
+// histograms (reservoir sampling), the other statistics use all the values
+const statsReservoirSize = 10000
+
+// Statistics of the numeric values of a field of the items
+type NumericStats struct {
+	Path   string  `json:"path"`
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	m2     float64
+	sample []float64
+	sorted bool
+	rnd    *rand.Rand
+}
+
+// A range of values [From, To) of a histogram, the last one includes To
+type HistogramBin struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+func (ns *NumericStats) add(v float64) {
+	ns.Count++
+	if ns.Count == 1 || v < ns.Min {
+		ns.Min = v
+	}
+	if ns.Count == 1 || v > ns.Max {
+		ns.Max = v
+	}
+	// Welford's algorithm for the mean and the variance
+	delta := v - ns.Mean
+	ns.Mean += delta / float64(ns.Count)
+	ns.m2 += delta * (v - ns.Mean)
+	if ns.Count > 1 {
+		ns.StdDev = math.Sqrt(ns.m2 / float64(ns.Count-1))
+	}
+
+	if len(ns.sample) < statsReservoirSize {
+		ns.sample = append(ns.sample, v)
+	} else if i := ns.rnd.Intn(ns.Count); i < statsReservoirSize {
+		ns.sample[i] = v
+	}
+	ns.sorted = false
+}
+
+func (ns *NumericStats) sortSample() {
+	if !ns.sorted {
+		sort.Float64s(ns.sample)
+		ns.sorted = true
+	}
+}
+
+// Returns the percentile `p` (0-100) of the values, exact if there are up to
+// statsReservoirSize values and estimated from a random sample of them if
+// there are more
+func (ns *NumericStats) Percentile(p float64) float64 {
+	ns.sortSample()
+	return percentile(ns.sample, p)
+}
+
+// Returns the histogram of the values in `bins` ranges of the same size
+// between Min and Max. With more than statsReservoirSize values the counts
+// are estimated from a random sample of them.
+func (ns *NumericStats) Histogram(bins int) []HistogramBin {
+	if ns.Count == 0 || bins < 1 {
+		return nil
+	}
+	if ns.Min == ns.Max {
+		return []HistogramBin{{From: ns.Min, To: ns.Max, Count: ns.Count}}
+	}
+	width := (ns.Max - ns.Min) / float64(bins)
+	hist := make([]HistogramBin, bins)
+	for i := range hist {
+		hist[i].From = ns.Min + float64(i)*width
+		hist[i].To = ns.Min + float64(i+1)*width
+	}
+	hist[bins-1].To = ns.Max
+	counts := make([]float64, bins)
+	for _, v := range ns.sample {
+		i := int((v - ns.Min) / width)
+		if i >= bins {
+			i = bins - 1
+		}
+		counts[i]++
+	}
+	scale := float64(ns.Count) / float64(len(ns.sample))
+	for i := range hist {
+		hist[i].Count = int(math.Round(counts[i] * scale))
+	}
+	return hist
+}
+
+// Statistics of the numeric fields of the items (nested fields with dots
+// and the elements of lists as `[]`, like ItemSchema), adding the items one
+// by one with a fixed memory per field
+type ItemStats struct {
+	Items  int
+	fields map[string]*NumericStats
+	rnd    *rand.Rand
+}
+
+func NewItemStats() *ItemStats {
+	return &ItemStats{fields: make(map[string]*NumericStats), rnd: rand.New(rand.NewSource(rand.Int63()))}
+}
+
+func (s *ItemStats) Add(item map[string]interface{}) {
+	s.add("", item)
+	s.Items++
+}
+
+func (s *ItemStats) add(path string, v interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, cv := range val {
+			s.add(joinPath(path, k), cv)
+		}
+	case []interface{}:
+		for _, ev := range val {
+			s.add(path+"[]", ev)
+		}
+	default:
+		n, ok := toNumber(v)
+		if !ok {
+			return
+		}
+		ns, ok := s.fields[path]
+		if !ok {
+			ns = &NumericStats{Path: path, rnd: s.rnd}
+			s.fields[path] = ns
+		}
+		ns.add(n)
+	}
+}
+
+// Returns the statistics of the numeric fields sorted by path
+func (s *ItemStats) Fields() []*NumericStats {
+	fields := make([]*NumericStats, 0, len(s.fields))
+	for _, ns := range s.fields {
+		fields = append(fields, ns)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return fields
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 )
 
@@ -61,6 +63,25 @@ func ProjectID(job_id string) string {
 	return result[1]
 }
 
+// Write `data` to the file `path` through a temporary file which is renamed
+// at the end, so the file is never left half written (e.g: state files)
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 /*
  The following code was borrwed:
  - http://stackoverflow.com/questions/21060945/simple-way-to-copy-a-file-in-golang
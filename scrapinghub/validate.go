@@ -0,0 +1,402 @@
+package scrapinghub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A JSON Schema to validate items. The keywords supported are type,
+// required, properties, additionalProperties (true or false), items, enum,
+// const, pattern, minLength, maxLength, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minItems and maxItems, plus the annotations (title,
+// description, ...); a schema with any other keyword is an error, so no rule
+// of the schema goes unchecked.
+type Schema struct {
+	types                []string
+	required             []string
+	properties           map[string]*Schema
+	additionalProperties *bool
+	items                *Schema
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	pattern              *regexp.Regexp
+	minLength, maxLength *int
+	minItems, maxItems   *int
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+}
+
+// Keywords of a schema which don't validate anything
+var schemaAnnotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "readOnly": true, "writeOnly": true,
+}
+
+var schemaKeywords = map[string]bool{
+	"type": true, "required": true, "properties": true, "additionalProperties": true, "items": true,
+	"enum": true, "const": true, "pattern": true, "minLength": true, "maxLength": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"minItems": true, "maxItems": true,
+}
+
+// Read the JSON Schema in the file `path`
+func LoadSchema(path string) (*Schema, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadSchema: %s", err)
+	}
+	schema, err := ParseSchema(content)
+	if err != nil {
+		return nil, fmt.Errorf("LoadSchema: %s: %s", path, err)
+	}
+	return schema, nil
+}
+
+func ParseSchema(content []byte) (*Schema, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	return parseSchema(doc, "")
+}
+
+func parseSchema(doc map[string]interface{}, path string) (*Schema, error) {
+	s := &Schema{}
+	at := path
+	if at == "" {
+		at = "the root"
+	}
+	wrong := func(keyword string) error {
+		return fmt.Errorf("wrong value of %s in %s", keyword, at)
+	}
+	intValue := func(keyword string) (*int, error) {
+		v, ok := doc[keyword]
+		if !ok {
+			return nil, nil
+		}
+		n, ok := v.(float64)
+		if !ok || n < 0 || n != float64(int(n)) {
+			return nil, wrong(keyword)
+		}
+		i := int(n)
+		return &i, nil
+	}
+	numValue := func(keyword string) (*float64, error) {
+		v, ok := doc[keyword]
+		if !ok {
+			return nil, nil
+		}
+		n, ok := v.(float64)
+		if !ok {
+			return nil, wrong(keyword)
+		}
+		return &n, nil
+	}
+	var err error
+
+	var unsupported []string
+	for keyword := range doc {
+		if !schemaKeywords[keyword] && !schemaAnnotations[keyword] {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, fmt.Errorf("keywords not supported in %s: %s", at, strings.Join(unsupported, ", "))
+	}
+
+	switch t := doc["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, e := range t {
+			name, ok := e.(string)
+			if !ok {
+				return nil, wrong("type")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, wrong("type")
+	}
+	if v, ok := doc["required"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, wrong("required")
+		}
+		for _, e := range list {
+			name, ok := e.(string)
+			if !ok {
+				return nil, wrong("required")
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if v, ok := doc["properties"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, wrong("properties")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, pv := range props {
+			pdoc, ok := pv.(map[string]interface{})
+			if !ok {
+				return nil, wrong("properties")
+			}
+			if s.properties[name], err = parseSchema(pdoc, joinPath(path, name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := doc["additionalProperties"]; ok {
+		// Only true or false, not a schema of the additional properties
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("additionalProperties in %s must be true or false, schemas are not supported", at)
+		}
+		s.additionalProperties = &b
+	}
+	if v, ok := doc["items"]; ok {
+		idoc, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, wrong("items")
+		}
+		if s.items, err = parseSchema(idoc, path+"[]"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := doc["enum"]; ok {
+		if s.enum, ok = v.([]interface{}); !ok {
+			return nil, wrong("enum")
+		}
+	}
+	s.constValue, s.hasConst = doc["const"]
+	if v, ok := doc["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, wrong("pattern")
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("wrong pattern in %s: %s", at, err)
+		}
+	}
+	if s.minLength, err = intValue("minLength"); err != nil {
+		return nil, err
+	}
+	if s.maxLength, err = intValue("maxLength"); err != nil {
+		return nil, err
+	}
+	if s.minItems, err = intValue("minItems"); err != nil {
+		return nil, err
+	}
+	if s.maxItems, err = intValue("maxItems"); err != nil {
+		return nil, err
+	}
+	if s.minimum, err = numValue("minimum"); err != nil {
+		return nil, err
+	}
+	if s.maximum, err = numValue("maximum"); err != nil {
+		return nil, err
+	}
+	if s.exclusiveMinimum, err = numValue("exclusiveMinimum"); err != nil {
+		return nil, err
+	}
+	if s.exclusiveMaximum, err = numValue("exclusiveMaximum"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// A rule of the schema not satisfied by a value of an item, e.g: the field
+// price with rule type
+type Violation struct {
+	Path    string `json:"path"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Returns the rules of the schema not satisfied by the item
+func (s *Schema) Validate(item interface{}) []Violation {
+	var violations []Violation
+	s.validate(item, "", &violations)
+	return violations
+}
+
+func (s *Schema) validate(v interface{}, path string, violations *[]Violation) {
+	add := func(rule, format string, args ...interface{}) {
+		at := path
+		if at == "" {
+			at = "."
+		}
+		*violations = append(*violations, Violation{Path: at, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	t := jsonType(v)
+	if len(s.types) > 0 {
+		ok := false
+		for _, name := range s.types {
+			if name == t || (name == "number" && t == "integer") {
+				ok = true
+			}
+		}
+		if !ok {
+			add("type", "expected %s, got %s", strings.Join(s.types, " or "), t)
+			// The other rules depend on the type
+			return
+		}
+	}
+	if s.enum != nil {
+		ok := false
+		for _, e := range s.enum {
+			if equalValues(v, e) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			add("enum", "value %s not in the enum", flatValue(v))
+		}
+	}
+	if s.hasConst && !equalValues(v, s.constValue) {
+		add("const", "expected %s, got %s", flatValue(s.constValue), flatValue(v))
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := val[name]; !ok {
+				*violations = append(*violations, Violation{Path: joinPath(path, name), Rule: "required",
+					Message: "missing required field"})
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := s.properties[k]; ok {
+				ps.validate(val[k], joinPath(path, k), violations)
+			} else if s.additionalProperties != nil && !*s.additionalProperties {
+				*violations = append(*violations, Violation{Path: joinPath(path, k), Rule: "additionalProperties",
+					Message: "field not allowed"})
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(val) < *s.minItems {
+			add("minItems", "%d elements, expected at least %d", len(val), *s.minItems)
+		}
+		if s.maxItems != nil && len(val) > *s.maxItems {
+			add("maxItems", "%d elements, expected at most %d", len(val), *s.maxItems)
+		}
+		if s.items != nil {
+			for _, e := range val {
+				s.items.validate(e, path+"[]", violations)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.minLength != nil && n < *s.minLength {
+			add("minLength", "length %d, expected at least %d", n, *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			add("maxLength", "length %d, expected at most %d", n, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			add("pattern", "%q doesn't match %s", val, s.pattern)
+		}
+	default:
+		n, ok := toNumber(v)
+		if !ok {
+			break
+		}
+		if s.minimum != nil && n < *s.minimum {
+			add("minimum", "%v is less than %v", n, *s.minimum)
+		}
+		if s.maximum != nil && n > *s.maximum {
+			add("maximum", "%v is greater than %v", n, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+			add("exclusiveMinimum", "%v is not greater than %v", n, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+			add("exclusiveMaximum", "%v is not less than %v", n, *s.exclusiveMaximum)
+		}
+	}
+}
+
+// An item not valid, with its offset in the job and the rules not satisfied
+type InvalidItem struct {
+	Offset     int                    `json:"offset"`
+	Violations []Violation            `json:"violations"`
+	Item       map[string]interface{} `json:"item"`
+}
+
+// Result of the validation of the items of a job
+type ValidationSummary struct {
+	JobID   string `json:"job_id"`
+	Items   int    `json:"items"`
+	Invalid int    `json:"invalid"`
+	// Number of violations of every rule, by "<path>: <rule>" (e.g: "price: type")
+	Rules map[string]int `json:"rules"`
+}
+
+// Validate the items of the job `job_id` against the schema while they are
+// streamed (using the count, offset and workers of `ls`). The items not valid
+// are passed to `invalid` (if not nil) with their offsets, which are only
+// right if the lines are in order (ls.Unordered not set).
+func ValidateItems(ls *LinesStream, job_id string, schema *Schema, invalid func(*InvalidItem) error) (*ValidationSummary, error) {
+	summary := &ValidationSummary{JobID: job_id, Rules: make(map[string]int)}
+	ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+	offset := ls.Offset
+	var failed error
+	for line := range ch_lines {
+		if failed != nil {
+			// Drain the stream to end it
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		var item map[string]interface{}
+		if err := dec.Decode(&item); err != nil {
+			failed = &ItemDecodeError{Offset: offset, Line: line, Err: err}
+			continue
+		}
+		summary.Items++
+		if violations := schema.Validate(item); len(violations) > 0 {
+			summary.Invalid++
+			for _, v := range violations {
+				summary.Rules[v.Path+": "+v.Rule]++
+			}
+			if invalid != nil {
+				if err := invalid(&InvalidItem{Offset: offset, Violations: violations, Item: item}); err != nil {
+					failed = err
+				}
+			}
+		}
+		offset++
+	}
+	for err := range errch {
+		if failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return summary, fmt.Errorf("ValidateItems: %s", failed)
+	}
+	return summary, nil
+}
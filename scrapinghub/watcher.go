@@ -0,0 +1,164 @@
+package scrapinghub
+
+import (
+	"fmt"
+	"time"
+)
+
+// Type of the events emitted by the Watcher
+type JobEventType string
+
+const (
+	JobScheduled       JobEventType = "scheduled"
+	JobStarted         JobEventType = "started"
+	JobFinished        JobEventType = "finished"
+	JobFailed          JobEventType = "failed"
+	JobCountersChanged JobEventType = "counters_changed"
+)
+
+// All the types of events emitted by the Watcher
+var JobEventTypes = []JobEventType{JobScheduled, JobStarted, JobFinished, JobFailed, JobCountersChanged}
+
+// Returns the event type named `name` (e.g: failed) or an error if there is
+// no such type
+func ParseJobEventType(name string) (JobEventType, error) {
+	for _, t := range JobEventTypes {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("ParseJobEventType: unknown event type %s", name)
+}
+
+// Represent a change in the lifecycle of a job (pending -> running ->
+// finished) or in its counters while running
+type JobEvent struct {
+	Type JobEventType `json:"type"`
+	Time time.Time    `json:"time"`
+	Job  Job          `json:"job"`
+}
+
+// The Watcher polls the jobs of the project `ProjectID` every `Interval`
+// and emits an event for every change in the state or counters of the jobs.
+// `Count` limits the number of (most recent) jobs listed in every poll and
+// `Filters` are applied to the list (see Jobs.List).
+type Watcher struct {
+	Conn      *Connection
+	ProjectID string
+	Interval  time.Duration
+	Count     int
+	Filters   map[string]string
+	jobs      map[string]Job
+}
+
+func countersChanged(a, b *Job) bool {
+	return a.ItemsScraped != b.ItemsScraped || a.ErrorsCount != b.ErrorsCount ||
+		a.Logs != b.Logs || a.ResponsesReceived != b.ResponsesReceived
+}
+
+// Returns the events between the previous state of the job `prev` (nil if
+// the job is new) and its current state `cur`
+func jobEvents(prev, cur *Job, now time.Time) []JobEvent {
+	var types []JobEventType
+	state := ""
+	if prev == nil {
+		types = append(types, JobScheduled)
+		state = "pending"
+	} else {
+		state = prev.State
+	}
+	if state == "pending" && cur.State != "pending" {
+		types = append(types, JobStarted)
+	}
+	if state != "finished" && cur.State == "finished" {
+		if cur.Failed() {
+			types = append(types, JobFailed)
+		} else {
+			types = append(types, JobFinished)
+		}
+	}
+	if prev != nil && prev.State == "running" && cur.State == "running" && countersChanged(prev, cur) {
+		types = append(types, JobCountersChanged)
+	}
+	events := make([]JobEvent, len(types))
+	for i, t := range types {
+		events[i] = JobEvent{Type: t, Time: now, Job: *cur}
+	}
+	return events
+}
+
+// Poll the jobs of the project once and returns the events since the
+// previous poll. The first poll only takes note of the current jobs.
+func (w *Watcher) Poll() ([]JobEvent, error) {
+	count := w.Count
+	if count <= 0 {
+		count = 100
+	}
+	var jobs Jobs
+	list, err := jobs.List(w.Conn, w.ProjectID, count, w.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	first := w.jobs == nil
+	current := make(map[string]Job)
+	var events []JobEvent
+	// The jobs are listed from the most recent, iterate them backwards so
+	// the events are sorted in time
+	for i := len(list.Jobs) - 1; i >= 0; i-- {
+		job := list.Jobs[i]
+		current[job.Id] = job
+		if first {
+			continue
+		}
+		if prev, ok := w.jobs[job.Id]; ok {
+			events = append(events, jobEvents(&prev, &job, now)...)
+		} else {
+			events = append(events, jobEvents(nil, &job, now)...)
+		}
+	}
+	w.jobs = current
+	return events, nil
+}
+
+// Poll the jobs of the project every `Interval` until `stop` is closed.
+// Returns a channel with the events and a channel for the errors polling the
+// API (the watcher keeps polling after an error).
+func (w *Watcher) Watch(stop <-chan struct{}) (<-chan JobEvent, <-chan error) {
+	out := make(chan JobEvent)
+	errch := make(chan error, 1)
+
+	go func() {
+		defer close(errch)
+		defer close(out)
+
+		interval := w.Interval
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		for {
+			events, err := w.Poll()
+			if err != nil {
+				// Drop the error if the previous one was not read yet
+				select {
+				case errch <- err:
+				default:
+				}
+			}
+			for _, ev := range events {
+				select {
+				case out <- ev:
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case <-time.After(interval):
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out, errch
+}
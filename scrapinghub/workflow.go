@@ -0,0 +1,418 @@
+package scrapinghub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Represent a step of a workflow: a spider run after all the steps it
+// depends on finished successfully. If `Foreach` is given, the step fans out
+// into one job for every value (available as {{item}} in the arguments).
+type WorkflowStep struct {
+	Name    string
+	Spider  string
+	Project string
+	Args    map[string]string
+	Tags    []string
+	Depends []string
+	Foreach []string
+	// Number of times a failed job (or a failed attempt to schedule it) is
+	// retried
+	Retries int
+}
+
+// Represent a workflow: a set of steps to run in order of their dependencies
+// (a DAG)
+type Workflow struct {
+	Name    string
+	Project string
+	Steps   []WorkflowStep `toml:"step"`
+}
+
+// Load the workflow file (TOML format) in `path` and check the steps and
+// their dependencies. e.g:
+//
+//	name = "books"
+//	project = "123"
+//
+//	[[step]]
+//	name = "discover"
+//	spider = "discover"
+//	retries = 2
+//
+//	[[step]]
+//	name = "details"
+//	spider = "details"
+//	depends = ["discover"]
+//	foreach = ["fiction", "history"]
+//	[step.args]
+//	source_job = "{{discover.job_id}}"
+//	category = "{{item}}"
+func LoadWorkflow(path string) (*Workflow, error) {
+	var wf Workflow
+	if _, err := toml.DecodeFile(path, &wf); err != nil {
+		return nil, fmt.Errorf("LoadWorkflow: can't parse workflow file %s: %s", path, err)
+	}
+	steps := make(map[string]*WorkflowStep)
+	for i := range wf.Steps {
+		step := &wf.Steps[i]
+		if step.Name == "" {
+			step.Name = step.Spider
+		}
+		if step.Spider == "" {
+			return nil, fmt.Errorf("LoadWorkflow: step #%d has no spider", i+1)
+		}
+		if step.Project == "" {
+			step.Project = wf.Project
+		}
+		if err := ValidateProjectID(step.Project); err != nil {
+			return nil, fmt.Errorf("LoadWorkflow: step '%s': %s", step.Name, err)
+		}
+		if _, ok := steps[step.Name]; ok {
+			return nil, fmt.Errorf("LoadWorkflow: duplicated step name '%s'", step.Name)
+		}
+		steps[step.Name] = step
+	}
+	for _, step := range wf.Steps {
+		for _, dep := range step.Depends {
+			if _, ok := steps[dep]; !ok {
+				return nil, fmt.Errorf("LoadWorkflow: step '%s' depends on unknown step '%s'", step.Name, dep)
+			}
+		}
+	}
+	// Check there are no cycles, visiting the steps in depth
+	visiting := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch visiting[name] {
+		case 1:
+			return fmt.Errorf("LoadWorkflow: dependency cycle found on step '%s'", name)
+		case 2:
+			return nil
+		}
+		visiting[name] = 1
+		for _, dep := range steps[name].Depends {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visiting[name] = 2
+		return nil
+	}
+	for _, step := range wf.Steps {
+		if err := visit(step.Name); err != nil {
+			return nil, err
+		}
+	}
+	return &wf, nil
+}
+
+// Status of the steps and jobs of a workflow
+const (
+	WorkflowPending  = "pending"
+	WorkflowRunning  = "running"
+	WorkflowFinished = "finished"
+	WorkflowFailed   = "failed"
+	WorkflowSkipped  = "skipped"
+)
+
+// Represent a job of a workflow step (one per value of Foreach)
+type WorkflowJob struct {
+	Item     string `json:"item,omitempty"`
+	JobId    string `json:"job_id"`
+	Attempts int    `json:"attempts"`
+	Status   string `json:"status"`
+}
+
+// Represent the state of a workflow step
+type WorkflowStepState struct {
+	Status string        `json:"status"`
+	Jobs   []WorkflowJob `json:"jobs,omitempty"`
+}
+
+// Represent something that happened running a workflow: a job scheduled,
+// finished, failed, etc. or an error querying the API.
+type WorkflowEvent struct {
+	Time   time.Time
+	Step   string
+	Item   string
+	JobId  string
+	Status string
+	Err    error
+}
+
+// The WorkflowRunner runs the steps of a workflow, polling every `Interval`
+// the jobs running. The state of the steps is persisted in the file
+// `StatePath` (if given) so an interrupted workflow resumes where it stopped.
+type WorkflowRunner struct {
+	Conn      *Connection
+	Workflow  *Workflow
+	StatePath string
+	Interval  time.Duration
+	State     map[string]*WorkflowStepState
+}
+
+// Load the state of the workflow from `StatePath`, the steps without state
+// are pending and the state of steps no longer in the workflow is dropped
+func (wr *WorkflowRunner) LoadState() error {
+	wr.State = make(map[string]*WorkflowStepState)
+	if wr.StatePath != "" {
+		content, err := ioutil.ReadFile(wr.StatePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(content, &wr.State); err != nil {
+				return fmt.Errorf("WorkflowRunner: can't read state file %s: %s", wr.StatePath, err)
+			}
+		}
+	}
+	steps := make(map[string]bool)
+	for _, step := range wr.Workflow.Steps {
+		steps[step.Name] = true
+		if _, ok := wr.State[step.Name]; !ok {
+			wr.State[step.Name] = &WorkflowStepState{Status: WorkflowPending}
+		}
+	}
+	for name := range wr.State {
+		if !steps[name] {
+			delete(wr.State, name)
+		}
+	}
+	return nil
+}
+
+func (wr *WorkflowRunner) saveState() error {
+	if wr.StatePath == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(wr.State, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(wr.StatePath, content)
+}
+
+// Replace the placeholders {{project}}, {{workflow}}, {{item}} and
+// {{<step>.job_id}} (the comma separated ids of the jobs of the step) in `s`
+func (wr *WorkflowRunner) expand(s string, step *WorkflowStep, item string) string {
+	pairs := []string{
+		"{{project}}", step.Project,
+		"{{workflow}}", wr.Workflow.Name,
+		"{{item}}", item,
+	}
+	for name, st := range wr.State {
+		var ids []string
+		for _, job := range st.Jobs {
+			if job.JobId != "" {
+				ids = append(ids, job.JobId)
+			}
+		}
+		pairs = append(pairs, "{{"+name+".job_id}}", strings.Join(ids, ","))
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+func (wr *WorkflowRunner) scheduleJob(step *WorkflowStep, job *WorkflowJob) error {
+	args := make(map[string]string)
+	for k, v := range step.Args {
+		args[k] = wr.expand(v, step, job.Item)
+	}
+	tags := make([]string, len(step.Tags))
+	for i, tag := range step.Tags {
+		tags[i] = wr.expand(tag, step, job.Item)
+	}
+	var jobs Jobs
+	job_id, err := jobs.Schedule(wr.Conn, step.Project, step.Spider, args, tags...)
+	if err != nil {
+		return err
+	}
+	job.JobId = job_id
+	job.Status = WorkflowRunning
+	return nil
+}
+
+// Returns the status that the pending step `step` should have given the
+// status of its dependencies
+func (wr *WorkflowRunner) dependenciesStatus(step *WorkflowStep) string {
+	status := WorkflowFinished
+	for _, dep := range step.Depends {
+		switch wr.State[dep].Status {
+		case WorkflowFailed, WorkflowSkipped:
+			return WorkflowSkipped
+		case WorkflowFinished:
+		default:
+			status = WorkflowPending
+		}
+	}
+	return status
+}
+
+// Do one pass over the steps of the workflow: schedule the steps ready to
+// run and check the jobs running. Returns the events of the pass.
+func (wr *WorkflowRunner) Step() []WorkflowEvent {
+	var events []WorkflowEvent
+	event := func(step *WorkflowStep, job *WorkflowJob, status string, err error) {
+		ev := WorkflowEvent{Time: time.Now(), Step: step.Name, Status: status, Err: err}
+		if job != nil {
+			ev.Item = job.Item
+			ev.JobId = job.JobId
+		}
+		events = append(events, ev)
+	}
+
+	for i := range wr.Workflow.Steps {
+		step := &wr.Workflow.Steps[i]
+		st := wr.State[step.Name]
+
+		if st.Status == WorkflowPending {
+			switch wr.dependenciesStatus(step) {
+			case WorkflowSkipped:
+				st.Status = WorkflowSkipped
+				event(step, nil, WorkflowSkipped, nil)
+				continue
+			case WorkflowPending:
+				continue
+			}
+			if len(st.Jobs) == 0 {
+				if len(step.Foreach) == 0 {
+					st.Jobs = []WorkflowJob{{Status: WorkflowPending}}
+				}
+				for _, item := range step.Foreach {
+					st.Jobs = append(st.Jobs, WorkflowJob{Item: item, Status: WorkflowPending})
+				}
+			}
+			st.Status = WorkflowRunning
+		}
+		if st.Status != WorkflowRunning {
+			continue
+		}
+
+		done := 0
+		failed := 0
+		for j := range st.Jobs {
+			job := &st.Jobs[j]
+			switch job.Status {
+			case WorkflowPending:
+				job.Attempts++
+				if err := wr.scheduleJob(step, job); err != nil {
+					event(step, job, "error", err)
+					if job.Attempts > step.Retries {
+						job.Status = WorkflowFailed
+						event(step, job, WorkflowFailed, nil)
+					}
+				} else {
+					event(step, job, "scheduled", nil)
+				}
+			case WorkflowRunning:
+				var jobs Jobs
+				info, err := jobs.JobInfo(wr.Conn, job.JobId)
+				if err != nil {
+					event(step, job, "error", err)
+					break
+				}
+				// A deleted job never finishes, it's failed
+				close_reason := info.CloseReason
+				if info.State == "deleted" {
+					close_reason = "deleted"
+				} else if info.State != "finished" {
+					break
+				}
+				if close_reason != "deleted" && !info.Failed() {
+					job.Status = WorkflowFinished
+					event(step, job, WorkflowFinished, nil)
+				} else if job.Attempts <= step.Retries {
+					// Scheduled again on the next pass
+					job.Status = WorkflowPending
+					event(step, job, WorkflowFailed+" ("+close_reason+"), retrying", nil)
+				} else {
+					job.Status = WorkflowFailed
+					event(step, job, WorkflowFailed+" ("+close_reason+")", nil)
+				}
+			}
+			switch job.Status {
+			case WorkflowFinished:
+				done++
+			case WorkflowFailed:
+				done++
+				failed++
+			}
+			// Save the state after every change, so an interrupted workflow
+			// never schedules the same job twice
+			if err := wr.saveState(); err != nil {
+				event(step, job, "error", err)
+			}
+		}
+		if done == len(st.Jobs) {
+			if failed > 0 {
+				st.Status = WorkflowFailed
+			} else {
+				st.Status = WorkflowFinished
+			}
+			event(step, nil, st.Status, nil)
+		}
+	}
+	if err := wr.saveState(); err != nil {
+		events = append(events, WorkflowEvent{Time: time.Now(), Status: "error", Err: err})
+	}
+	return events
+}
+
+// Returns true when all the steps are finished, failed or skipped
+func (wr *WorkflowRunner) Done() bool {
+	for _, st := range wr.State {
+		if st.Status == WorkflowPending || st.Status == WorkflowRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// Run the workflow until all the steps are done. Returns a channel with the
+// events of the run and a channel of errors, an error is sent if some step
+// failed.
+func (wr *WorkflowRunner) Run() (<-chan WorkflowEvent, <-chan error) {
+	out := make(chan WorkflowEvent)
+	errch := make(chan error, 1)
+
+	go func() {
+		defer close(errch)
+		defer close(out)
+
+		if wr.State == nil {
+			if err := wr.LoadState(); err != nil {
+				errch <- err
+				return
+			}
+		}
+		interval := wr.Interval
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		for {
+			for _, ev := range wr.Step() {
+				out <- ev
+			}
+			if wr.Done() {
+				break
+			}
+			time.Sleep(interval)
+		}
+		var failed []string
+		for _, step := range wr.Workflow.Steps {
+			if st := wr.State[step.Name].Status; st != WorkflowFinished {
+				failed = append(failed, step.Name+" ("+st+")")
+			}
+		}
+		if len(failed) > 0 {
+			errch <- fmt.Errorf("WorkflowRunner: workflow '%s' not completed, steps: %s", wr.Workflow.Name, strings.Join(failed, ", "))
+		}
+	}()
+	return out, errch
+}
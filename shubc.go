@@ -1,16 +1,25 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/scrapinghub/shubc/scrapinghub"
+	"io"
 	"log"
+	"math"
+	"net/http"
 	"os"
+	"os/signal"
 	"os/user"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -27,25 +36,38 @@ func dashes(n int) string {
 }
 
 func print_out(flags *PFlags, format string, args ...interface{}) {
-	output := flags.Output
 	line := fmt.Sprintf(format, args...)
-	var out *os.File = os.Stdout
 	var err error
-	if output == "" {
-		fmt.Println(line)
-		return
-	}
-	out, err = os.OpenFile(output, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
-	if err != nil {
-		log.Fatalf("Error writing output to file: %s\n", err)
+	if flags.Output == "" {
+		_, err = fmt.Println(line)
 	} else {
-		fmt.Fprintln(out, line)
+		// The output file is opened once and closed when the command ends
+		if flags.out == nil {
+			out, err := open_output_file(flags, flags.Output, flags.Compress, true)
+			if err != nil {
+				log.Fatalf("Error writing output to file: %s\n", err)
+			}
+			flags.out = out
+		}
+		_, err = fmt.Fprintln(flags.out, line)
 	}
-	defer func() {
-		if err := out.Close(); err != nil {
-			panic(err)
+	// The first error is reported by close_output when the command ends
+	if err != nil && flags.out_err == nil {
+		flags.out_err = err
+	}
+}
+
+// Close the output file opened by print_out, returns the first error
+// writing the output or the error closing it
+func close_output(flags *PFlags) error {
+	if flags.out != nil {
+		err := flags.out.Close()
+		flags.out = nil
+		if flags.out_err == nil {
+			flags.out_err = err
 		}
-	}()
+	}
+	return flags.out_err
 }
 
 func find_apikey() string {
@@ -91,20 +113,103 @@ func equality_list_to_map(data []string) map[string]string {
 	return result
 }
 
+// Parse the options given after the command name (e.g: items <job_id> -o out.jl)
+// and returns the remaining positional arguments. Only the defined options are
+// parsed, any other token (and all of them after "--") is an argument of the
+// command as when the options are given before it.
+func parse_cmd_flags(args []string) []string {
+	var positional []string
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			positional = append(positional, args...)
+			break
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		value, has_value := "", false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value, has_value = name[:i], name[i+1:], true
+		}
+		fl := flag.Lookup(name)
+		if !strings.HasPrefix(arg, "-") || name == "" || fl == nil {
+			positional = append(positional, arg)
+			continue
+		}
+		if bf, ok := fl.Value.(interface{ IsBoolFlag() bool }); !has_value && ok && bf.IsBoolFlag() {
+			value = "true"
+		} else if !has_value {
+			if len(args) == 0 {
+				log.Fatalf("Missing value of option -%s\n", name)
+			}
+			value = args[0]
+			args = args[1:]
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("Wrong value %q of option -%s: %s\n", value, name, err)
+		}
+	}
+	return positional
+}
+
+// Returns the keys of the map sorted
+func sorted_keys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 type PFlagsCSV struct {
 	IncludeHeaders bool
 	Fields         string
 }
 
 type PFlags struct {
-	Count       int
-	Offset      int
-	Output      string
-	AsJsonLines bool
-	AsCSV       bool
-	CSVFlags    PFlagsCSV
-	Tailing     bool
-	Debug       bool
+	Count         int
+	Offset        int
+	Output        string
+	AsJsonLines   bool
+	AsCSV         bool
+	CSVFlags      PFlagsCSV
+	Tailing       bool
+	Debug         bool
+	Key           string
+	Interval      time.Duration
+	NotifyOn      string
+	Project       string
+	Sinks         string
+	Listen        string
+	Since         string
+	Level         string
+	Logger        string
+	Grep          string
+	Context       int
+	Format        string
+	Resume        bool
+	Workers       int
+	Unordered     bool
+	To            string
+	Compress      string
+	Separator     string
+	Lists         string
+	ListSeparator string
+	Delimiter     string
+	Quote         string
+	Where         string
+	Select        string
+	Schema        string
+	Invalid       string
+	Duplicates    string
+	Spider        string
+	S3Endpoint    string
+	N             int
+	Random        bool
+	Bins          int
+	out           io.WriteCloser
+	out_err       error
 }
 
 /** Commands **/
@@ -128,12 +233,40 @@ func cmd_help() {
 	fmt.Println("     update <job_id> [args]                     - update the job with <job_id> using the `args` given")
 	fmt.Println("     stop <job_id>                              - stop the job with <job_id>")
 	fmt.Println("     delete <job_id>                            - delete the job with <job_id>")
+	fmt.Println("     job-diff <job_a> <job_b>                   - compare the information of two jobs (and their items if -key is given)")
+	fmt.Println("     watch <project_id> [filters]               - poll the jobs of project_id and print their state changes as JsonLines (count & interval available)")
+	fmt.Println("     notify [filters]                           - notify the job events given in -on of the jobs of -project to the sinks in -sinks file")
+	fmt.Println("     retry-failed <project_id> [reasons=r1,r2] [max_errors=N] [max_attempts=N] - re-schedule the last job of the spiders which failed (count available)")
+
+	fmt.Println("   Monitoring: ")
+	fmt.Println("     exporter [project_id ...]                  - serve Prometheus metrics of the jobs of the projects given (or -project) on -listen address")
+	fmt.Println("     report <project_id>                        - health report of the spiders of project_id in the last -since period compared with the previous one (format available)")
+
+	fmt.Println("   Scheduler: ")
+	fmt.Println("     scheduler <schedule_file>                  - run the spiders of the schedule file periodically (daemon)")
+	fmt.Println("     schedule-plan <schedule_file>              - list the next runs of the schedule file (count available, default 5 per job)")
+	fmt.Println("     workflow run <workflow_file>               - run the spiders of the workflow file in order of their dependencies")
+	fmt.Println("     workflow status <workflow_file>            - print the state of the workflow steps and jobs")
 
 	fmt.Println("   Items API: ")
-	fmt.Println("     items <job_id>                             - print to stdout the items for <job_id> (count & offset available)")
+	fmt.Println("     items <job_id>                             - print to stdout the items for <job_id> (count, offset & workers available)")
+	fmt.Println("     items <job_id> [job_id ...] -to <dest>     - export the items of the jobs to sqlite://<file>?table=<table>")
+	fmt.Println("     items <job_id> [job_id ...] -format parquet -o <file> - export the items of the jobs to a Parquet file (compress available)")
+	fmt.Println("     items <job_id> [job_id ...] -format csv    - write the items as CSV (or tsv) with headers flattening the nested fields (fields, sep, lists, list_sep, delimiter & quote available)")
+	fmt.Println("     items <job_id> -where <expr> -select <fields> - keep only the items matching <expr> and the fields given, in any output format (e.g: -where 'price > 10 && category == \"books\"' -select name,price,url)")
+	fmt.Println("     items-schema <job_id>                      - report the fields of the items: types, fill rate, distinct values, lengths and samples (count, offset, workers & format available, -format jsonschema for the JSON Schema)")
+	fmt.Println("     validate <job_id> -schema <file>           - validate the items against the JSON Schema in <file>, exit code 2 if any item is not valid (count, offset, workers, invalid & format available)")
+	fmt.Println("     items-dedup <job_id> [job_id ...]          - write the unique items of the jobs by the fields of -key, or by their content (count, offset, workers, duplicates, to & format available)")
+	fmt.Println("     items-merge <project_id> [filters]         - write the items of the jobs started in the last -since period to a single output with their _job_id and _job_started (spider, workers, where, select, to & format available)")
+	fmt.Println("     items-sample <job_id> -n <n> [-random]     - print a sample of n items of the job retrieving only those items, spread over the job or at random offsets (jl & workers available)")
+	fmt.Println("     items-stats <job_id> [-n <n> [-random]]    - percentiles and histograms of the numeric fields of the items, or of a sample of n items (count, offset, workers, bins & format available)")
+	fmt.Println("     items <job_id> -jl -o <file> -resume       - download the items to <file> saving checkpoints, resuming the download if it was interrupted")
+	fmt.Println("     items <job_id> -o s3://<bucket>/<key>      - upload the output to S3 (also for log & items-merge), placeholders {project}, {spider} & {job} available (s3_endpoint available)")
 
 	fmt.Println("   Logs API: ")
-	fmt.Println("     log <job_id>                               - print to stdout the log for the job `job_id` (count & offset available)")
+	fmt.Println("     log <job_id>                               - print to stdout the log for the job `job_id` (count, offset & workers available)")
+	fmt.Println("     log <job_id> -format json                  - print the records of the log as JsonLines: time, level, logger, message and raw lines, tracebacks in the record before them (tail available)")
+	fmt.Println("     log <job_id> -level WARNING -grep <regexp> - print only the records of the log of a level or higher, of a logger, matching a regexp or of the last -since period (logger, since, C, tail & format available)")
 
 	fmt.Println("   Eggs API: ")
 	fmt.Println("     eggs-add <project_id> <path> [name=n version=v] - add the egg in `path` to the project `project_id`. By default it guess the name and version from `path`, but can be given using name=eggname and version=XXX.")
@@ -310,6 +443,88 @@ func cmd_jobs_delete(conn *scrapinghub.Connection, args []string, flags *PFlags)
 	}
 }
 
+func cmd_job_diff(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 2 {
+		log.Fatalf("Missing arguments: <job_a> and <job_b>\n")
+	}
+	job_a := args[0]
+	job_b := args[1]
+
+	var jobs_a, jobs_b scrapinghub.Jobs
+	info_a, err := jobs_a.JobInfo(conn, job_a)
+	if err != nil {
+		log.Fatalf("job-diff error: %s\n", err)
+	}
+	info_b, err := jobs_b.JobInfo(conn, job_b)
+	if err != nil {
+		log.Fatalf("job-diff error: %s\n", err)
+	}
+	jd := scrapinghub.DiffJobs(info_a, info_b)
+
+	outfmt := "| %-30s | %30s | %30s | %12s | %10s |\n"
+	print_out(flags, outfmt, "key", job_a, job_b, "delta", "change")
+	print_out(flags, "%s", dashes(130))
+	print_out(flags, outfmt, "spider", info_a.Spider, info_b.Spider, "", "")
+	print_out(flags, outfmt, "version", info_a.Version, info_b.Version, "", "")
+	print_out(flags, outfmt, "close_reason", info_a.CloseReason, info_b.CloseReason, "", "")
+	print_out(flags, "%s", dashes(130))
+	for _, c := range jd.Counters {
+		change := "n/a"
+		if pct, ok := c.PercentChange(); ok {
+			change = fmt.Sprintf("%+.2f%%", pct)
+		}
+		print_out(flags, outfmt, c.Name, fmt.Sprintf("%d", c.A), fmt.Sprintf("%d", c.B), fmt.Sprintf("%+d", c.Delta), change)
+	}
+	print_out(flags, "%s", dashes(130))
+	for _, k := range sorted_keys(jd.ArgsAdded) {
+		print_out(flags, outfmt, "arg added", "", fmt.Sprintf("%s = %s", k, jd.ArgsAdded[k]), "", "")
+	}
+	for _, k := range sorted_keys(jd.ArgsRemoved) {
+		print_out(flags, outfmt, "arg removed", fmt.Sprintf("%s = %s", k, jd.ArgsRemoved[k]), "", "", "")
+	}
+	for _, k := range sorted_keys(jd.ArgsChanged) {
+		v := jd.ArgsChanged[k]
+		print_out(flags, outfmt, "arg changed", fmt.Sprintf("%s = %s", k, v[0]), fmt.Sprintf("%s = %s", k, v[1]), "", "")
+	}
+	for _, t := range jd.TagsAdded {
+		print_out(flags, outfmt, "tag added", "", t, "", "")
+	}
+	for _, t := range jd.TagsRemoved {
+		print_out(flags, outfmt, "tag removed", t, "", "", "")
+	}
+	print_out(flags, "%s", dashes(130))
+
+	if flags.Key == "" {
+		return
+	}
+	ls := scrapinghub.LinesStream{Conn: conn}
+	idiff, err := ls.DiffItems(job_a, job_b, flags.Key)
+	if err != nil {
+		log.Fatalf("job-diff error: %s\n", err)
+	}
+	outfmt = "| %-30s | %10s |\n"
+	print_out(flags, outfmt, "items (key: "+idiff.Key+")", "count")
+	print_out(flags, "%s", dashes(47))
+	print_out(flags, outfmt, "added", fmt.Sprintf("%d", len(idiff.Added)))
+	print_out(flags, outfmt, "removed", fmt.Sprintf("%d", len(idiff.Removed)))
+	print_out(flags, outfmt, "changed", fmt.Sprintf("%d", len(idiff.Changed)))
+	print_out(flags, outfmt, "unchanged", fmt.Sprintf("%d", idiff.Unchanged))
+	print_out(flags, outfmt, "without key on "+job_a, fmt.Sprintf("%d", idiff.MissingKeyA))
+	print_out(flags, outfmt, "without key on "+job_b, fmt.Sprintf("%d", idiff.MissingKeyB))
+	print_out(flags, outfmt, "duplicated key on "+job_a, fmt.Sprintf("%d", idiff.DuplicateKeyA))
+	print_out(flags, outfmt, "duplicated key on "+job_b, fmt.Sprintf("%d", idiff.DuplicateKeyB))
+	print_out(flags, "%s", dashes(47))
+	for _, k := range idiff.Added {
+		print_out(flags, "+ %s", k)
+	}
+	for _, k := range idiff.Removed {
+		print_out(flags, "- %s", k)
+	}
+	for _, k := range idiff.Changed {
+		print_out(flags, "~ %s", k)
+	}
+}
+
 func cmd_items(conn *scrapinghub.Connection, args []string, flags *PFlags) {
 	if len(args) < 1 {
 		log.Fatalf("Missing argument: <job_id>\n")
@@ -318,39 +533,613 @@ func cmd_items(conn *scrapinghub.Connection, args []string, flags *PFlags) {
 	job_id := args[0]
 	count := flags.Count
 	offset := flags.Offset
-	ls := scrapinghub.LinesStream{Conn: conn, Count: count, Offset: offset}
+	ls := scrapinghub.LinesStream{Conn: conn, Count: count, Offset: offset,
+		Workers: flags.Workers, Unordered: flags.Unordered}
 
-	if flags.AsJsonLines {
+	var fields []string
+	if flags.Select != "" {
+		fields = strings.Split(flags.Select, ",")
+	}
+	filter, err := scrapinghub.NewItemFilter(flags.Where, fields)
+	if err != nil {
+		log.Fatalf("items error: %s\n", err)
+	}
+	// With several jobs {project} is replaced only if all of them are of the
+	// same project, and {spider} and {job} are not available
+	output_job := job_id
+	output_project := scrapinghub.ProjectID(job_id)
+	if len(args) > 1 {
+		output_job = ""
+		for _, id := range args[1:] {
+			if scrapinghub.ProjectID(id) != output_project {
+				output_project = ""
+			}
+		}
+	}
+	if err := expand_output(conn, flags, output_project, "", output_job); err != nil {
+		log.Fatalf("items error: %s\n", err)
+	}
+
+	if flags.To != "" || flags.Format == "parquet" || flags.Format == "csv" || flags.Format == "tsv" {
+		items_export(conn, args, filter, flags)
+	} else if flags.Resume {
+		if !flags.AsJsonLines || flags.Output == "" {
+			log.Fatalf("items error: -resume requires -jl and -o\n")
+		}
+		if flags.Unordered {
+			log.Fatalf("items error: -resume can't be used with -unordered\n")
+		}
+		if compression, err := output_compression(flags.Output, flags.Compress); err != nil || compression != "none" {
+			log.Fatalf("items error: -resume can't be used with a compressed output\n")
+		}
+		if strings.HasPrefix(flags.Output, "s3://") {
+			log.Fatalf("items error: -resume requires a local output file\n")
+		}
+		items_resumable(conn, job_id, filter, flags)
+	} else if flags.AsJsonLines {
 		ch_lines, errch := ls.ItemsAsJsonLines(job_id)
 
 		for line := range ch_lines {
-			print_out(flags, line)
+			line, ok, err := filter.ApplyLine(line)
+			if err != nil {
+				log.Fatalf("items error: %s\n", err)
+			}
+			if ok {
+				print_out(flags, "%s", line)
+			}
 		}
 		for err := range errch {
 			log.Fatalf("items error: %s\n", err)
 		}
 	} else if flags.AsCSV {
+		if flags.Where != "" || flags.Select != "" {
+			log.Fatalf("items error: -where and -select can't be used with the CSV of the server (-csv), use -format csv\n")
+		}
 		ch_lines, errch := ls.ItemsAsCSV(job_id, flags.CSVFlags.IncludeHeaders, flags.CSVFlags.Fields)
 		for line := range ch_lines {
-			print_out(flags, line)
+			print_out(flags, "%s", line)
 		}
 		for err := range errch {
 			log.Fatalf("items error: %s\n", err)
 		}
 	} else {
-		items, err := scrapinghub.RetrieveItems(conn, job_id, count, offset)
+		it, err := scrapinghub.NewItemIterator(conn, job_id, count, offset)
 		if err != nil {
 			log.Fatalf("items error: %s\n", err)
 		}
-		for i, e := range items {
-			print_out(flags, "Item %5d %s\n", i, dashes(129))
-			for k, v := range e {
-				//fmt.Printf("| %-33s | %100s |\n", k, fmt.Sprintf("%v", v))
-				print_out(flags, "| %-33s | %100s |\n", k, fmt.Sprintf("%v", v))
+		defer it.Close()
+		for it.Next() {
+			item, ok := filter.Apply(it.Item())
+			if !ok {
+				continue
+			}
+			print_item_table(flags, it.Offset(), item)
+		}
+		if err := it.Err(); err != nil {
+			log.Fatalf("items error: %s\n", err)
+		}
+	}
+}
+
+func print_item_table(flags *PFlags, offset int, item map[string]interface{}) {
+	print_out(flags, "Item %5d %s\n", offset, dashes(129))
+	for k, v := range item {
+		print_out(flags, "| %-33s | %100s |\n", k, fmt.Sprintf("%v", v))
+	}
+	print_out(flags, "%s", dashes(140))
+}
+
+// Export the items of the jobs to the destination given in -to
+func items_export(conn *scrapinghub.Connection, job_ids []string, filter *scrapinghub.ItemFilter, flags *PFlags) {
+	w, err := open_item_writer(flags)
+	if err != nil {
+		log.Fatalf("items error: %s\n", err)
+	}
+	for _, job_id := range job_ids {
+		ls := scrapinghub.LinesStream{Conn: conn, Count: flags.Count, Offset: flags.Offset, Workers: flags.Workers}
+		ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+		exported := 0
+		for line := range ch_lines {
+			item, err := decode_item(line)
+			if err != nil {
+				log.Fatalf("items error: job %s: can't decode item: %s\n", job_id, err)
+			}
+			item, ok := filter.Apply(item)
+			if !ok {
+				continue
+			}
+			if err := w.Write(job_id, item); err != nil {
+				log.Fatalf("items error: %s\n", err)
+			}
+			exported++
+		}
+		for err := range errch {
+			log.Fatalf("items error: %s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d items of job %s\n", exported, job_id)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatalf("items error: %s\n", err)
+	}
+}
+
+// Download the items of the job as JsonLines to the output file saving
+// checkpoints of the progress, to resume the download if it's interrupted
+func items_resumable(conn *scrapinghub.Connection, job_id string, filter *scrapinghub.ItemFilter, flags *PFlags) {
+	count := flags.Count
+	offset := flags.Offset
+	cw, err := scrapinghub.OpenCheckpointWriter(flags.Output, flags.Output+".checkpoint", job_id, offset)
+	if err != nil {
+		log.Fatalf("items error: %s\n", err)
+	}
+	defer cw.Close()
+
+	if cw.Resumed != nil {
+		if count > 0 {
+			count -= cw.Resumed.Offset - offset
+			if count <= 0 {
+				return
+			}
+		}
+		offset = cw.Resumed.Offset
+		fmt.Fprintf(os.Stderr, "Resuming download of job %s from item %d\n", job_id, offset)
+	}
+
+	// Offset of the last batch retrieved, when its first line is received
+	// all the previous lines are already written
+	var checkpoint int64 = -1
+	ls := scrapinghub.LinesStream{Conn: conn, Count: count, Offset: offset, Workers: flags.Workers,
+		Checkpoint: func(offset int) { atomic.StoreInt64(&checkpoint, int64(offset)) }}
+	ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+	for line := range ch_lines {
+		if int64(offset) == atomic.LoadInt64(&checkpoint) {
+			if err := cw.Save(offset); err != nil {
+				log.Fatalf("items error: can't save checkpoint: %s\n", err)
+			}
+		}
+		// The offset counts all the items, also the ones filtered
+		offset++
+		line, ok, err := filter.ApplyLine(line)
+		if err != nil {
+			log.Fatalf("items error: %s\n", err)
+		}
+		if !ok {
+			continue
+		}
+		if _, err := fmt.Fprintln(cw, line); err != nil {
+			log.Fatalf("items error: %s\n", err)
+		}
+	}
+	// All the lines received are written, save the progress even on errors
+	if err := cw.Save(offset); err != nil {
+		log.Fatalf("items error: can't save checkpoint: %s\n", err)
+	}
+	for err := range errch {
+		log.Fatalf("items error: %s\n", err)
+	}
+}
+
+// Statistics of the fields of the items of a job, or its JSON Schema with
+// -format jsonschema
+func cmd_items_schema(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <job_id>\n")
+	}
+	job_id := args[0]
+	ls := scrapinghub.LinesStream{Conn: conn, Count: flags.Count, Offset: flags.Offset,
+		Workers: flags.Workers, Unordered: flags.Unordered}
+	schema := scrapinghub.NewItemSchema()
+	ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+	for line := range ch_lines {
+		item, err := decode_item(line)
+		if err != nil {
+			log.Fatalf("items-schema error: can't decode item: %s\n", err)
+		}
+		schema.Add(item)
+	}
+	for err := range errch {
+		log.Fatalf("items-schema error: %s\n", err)
+	}
+
+	if flags.Format == "jsonschema" {
+		content, err := json.MarshalIndent(schema.JSONSchema(), "", "  ")
+		if err != nil {
+			log.Fatalf("items-schema error: %s\n", err)
+		}
+		print_out(flags, "%s", content)
+		return
+	}
+	fields := schema.Fields()
+	t := table{
+		Title:   fmt.Sprintf("Job %s: %d items, %d fields", job_id, schema.Items, len(fields)),
+		Headers: []string{"field", "types", "fill %", "distinct", "min len", "max len", "samples"},
+	}
+	for _, f := range fields {
+		var types []string
+		for name, n := range f.Types {
+			types = append(types, fmt.Sprintf("%s:%d", name, n))
+		}
+		sort.Strings(types)
+		min_len, max_len := "-", "-"
+		if f.MinLength >= 0 {
+			min_len, max_len = strconv.Itoa(f.MinLength), strconv.Itoa(f.MaxLength)
+		}
+		samples := make([]string, len(f.Samples))
+		for i, sample := range f.Samples {
+			if runes := []rune(sample); len(runes) > 30 {
+				sample = string(runes[:27]) + "..."
+			}
+			samples[i] = sample
+		}
+		distinct := fmt.Sprintf("%d", f.Cardinality)
+		if !f.CardinalityExact {
+			distinct = "~" + distinct
+		}
+		t.Rows = append(t.Rows, []string{f.Path, strings.Join(types, " "), fmt.Sprintf("%.1f", f.FillRate),
+			distinct, min_len, max_len, strings.Join(samples, ", ")})
+	}
+	data := map[string]interface{}{"job_id": job_id, "items": schema.Items, "fields": fields}
+	render_tables(flags, []table{t}, data)
+}
+
+// Validate the items of a job against the JSON Schema of -schema, writing the
+// items not valid to -invalid. Exits with code 2 if any item is not valid.
+func cmd_validate(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <job_id>\n")
+	}
+	if flags.Schema == "" {
+		log.Fatalf("validate error: missing the JSON Schema file (-schema)\n")
+	}
+	if flags.Unordered {
+		log.Fatalf("validate error: -unordered can't be used, the offsets of the items would be wrong\n")
+	}
+	job_id := args[0]
+	schema, err := scrapinghub.LoadSchema(flags.Schema)
+	if err != nil {
+		log.Fatalf("validate error: %s\n", err)
+	}
+
+	var invalid func(*scrapinghub.InvalidItem) error
+	if flags.Invalid != "" {
+		file, err := open_output_file(flags, flags.Invalid, "", false)
+		if err != nil {
+			log.Fatalf("validate error: %s\n", err)
+		}
+		defer file.Close()
+		enc := json.NewEncoder(file)
+		invalid = func(item *scrapinghub.InvalidItem) error {
+			return enc.Encode(item)
+		}
+	}
+	ls := scrapinghub.LinesStream{Conn: conn, Count: flags.Count, Offset: flags.Offset, Workers: flags.Workers}
+	summary, err := scrapinghub.ValidateItems(&ls, job_id, schema, invalid)
+	if err != nil {
+		log.Fatalf("validate error: %s\n", err)
+	}
+
+	percent := 0.0
+	if summary.Items > 0 {
+		percent = 100 * float64(summary.Invalid) / float64(summary.Items)
+	}
+	t := table{
+		Title:   fmt.Sprintf("Job %s: %d items, %d not valid (%.1f%%)", job_id, summary.Items, summary.Invalid, percent),
+		Headers: []string{"rule", "violations"},
+	}
+	var rules []string
+	for rule := range summary.Rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if summary.Rules[rules[i]] != summary.Rules[rules[j]] {
+			return summary.Rules[rules[i]] > summary.Rules[rules[j]]
+		}
+		return rules[i] < rules[j]
+	})
+	for _, rule := range rules {
+		t.Rows = append(t.Rows, []string{rule, strconv.Itoa(summary.Rules[rule])})
+	}
+	render_tables(flags, []table{t}, summary)
+
+	if summary.Invalid > 0 {
+		if err := close_output(flags); err != nil {
+			log.Fatalf("Error writing output: %s\n", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// Write the unique items of the jobs (by the fields of -key, or by their
+// content) to the output, or to the destination of -to or -format, and the
+// duplicates found to the -duplicates file
+func cmd_items_dedup(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <job_id>\n")
+	}
+	var fields []string
+	if flags.Key != "" {
+		fields = strings.Split(flags.Key, ",")
+	}
+	dedup, err := scrapinghub.NewDeduplicator(fields, "")
+	if err != nil {
+		log.Fatalf("items-dedup error: %s\n", err)
+	}
+	err = items_dedup(conn, args, dedup, flags)
+	// Remove the files of the set of keys before exiting on errors too
+	if cerr := dedup.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("items-dedup error: %s\n", err)
+	}
+}
+
+// Write the first item of every key of the jobs to the output (see
+// cmd_items_dedup) and the duplicated ones to -duplicates
+func items_dedup(conn *scrapinghub.Connection, job_ids []string, dedup *scrapinghub.Deduplicator, flags *PFlags) error {
+	var w ItemWriter
+	var err error
+	if flags.To != "" || flags.Format == "parquet" || flags.Format == "csv" || flags.Format == "tsv" {
+		if w, err = open_item_writer(flags); err != nil {
+			return err
+		}
+	}
+	var dups io.WriteCloser
+	var report *json.Encoder
+	if flags.Duplicates != "" {
+		if dups, err = open_output_file(flags, flags.Duplicates, "", false); err != nil {
+			return err
+		}
+		defer func() {
+			if dups != nil {
+				dups.Close()
+			}
+		}()
+		report = json.NewEncoder(dups)
+	}
+
+	total, unique, no_key := 0, 0, 0
+	for _, job_id := range job_ids {
+		ls := scrapinghub.LinesStream{Conn: conn, Count: flags.Count, Offset: flags.Offset, Workers: flags.Workers}
+		ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+		offset := flags.Offset
+		items, job_unique := 0, 0
+		for line := range ch_lines {
+			item, err := decode_item(line)
+			if err != nil {
+				return fmt.Errorf("job %s: can't decode item: %s", job_id, err)
+			}
+			key, duplicate, err := dedup.Check(item)
+			if err != nil {
+				return err
+			}
+			items++
+			if key == "" {
+				no_key++
+			}
+			if duplicate {
+				if report != nil {
+					dup := map[string]interface{}{"job_id": job_id, "offset": offset, "key": key}
+					if err := report.Encode(dup); err != nil {
+						return err
+					}
+				}
+			} else {
+				job_unique++
+				if w != nil {
+					if err := w.Write(job_id, item); err != nil {
+						return err
+					}
+				} else {
+					print_out(flags, "%s", line)
+				}
+			}
+			offset++
+		}
+		for err := range errch {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Job %s: %d items, %d unique, %d duplicates\n", job_id, items, job_unique, items-job_unique)
+		total += items
+		unique += job_unique
+	}
+	if w != nil {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	if dups != nil {
+		err := dups.Close()
+		dups = nil
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Total: %d items, %d unique, %d duplicates", total, unique, total-unique)
+	if no_key > 0 {
+		fmt.Fprintf(os.Stderr, ", %d without key (kept)", no_key)
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// Write the items of all the jobs of the project started in the last -since
+// period (of the spider of -spider and matching the filters) to a single
+// output, annotated with the job id and start time of their job
+func cmd_items_merge(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <project_id>\n")
+	}
+	project_id := args[0]
+	filters := equality_list_to_map(args[1:])
+	if flags.Spider != "" {
+		filters["spider"] = flags.Spider
+	}
+	if flags.Since == "" {
+		flags.Since = default_since
+	}
+	period, err := parse_duration(flags.Since)
+	if err != nil || period <= 0 {
+		log.Fatalf("items-merge error: wrong -since value: %s\n", flags.Since)
+	}
+	var fields []string
+	if flags.Select != "" {
+		fields = strings.Split(flags.Select, ",")
+	}
+	filter, err := scrapinghub.NewItemFilter(flags.Where, fields)
+	if err != nil {
+		log.Fatalf("items-merge error: %s\n", err)
+	}
+	if err := expand_output(conn, flags, project_id, flags.Spider, ""); err != nil {
+		log.Fatalf("items-merge error: %s\n", err)
+	}
+
+	var jobs scrapinghub.Jobs
+	jobs_list, err := jobs.ListSince(conn, project_id, time.Now().Add(-period), filters)
+	if err != nil {
+		log.Fatalf("items-merge error: %s\n", err)
+	}
+	if len(jobs_list) == 0 {
+		log.Fatalf("items-merge error: no jobs found in project %s since %s\n", project_id, flags.Since)
+	}
+	// The jobs are listed from the most recent, merge them in order
+	sort.SliceStable(jobs_list, func(i, j int) bool {
+		si, _ := jobs_list[i].Started()
+		sj, _ := jobs_list[j].Started()
+		return si.Before(sj)
+	})
+
+	w, err := open_item_writer(flags)
+	if err != nil {
+		log.Fatalf("items-merge error: %s\n", err)
+	}
+	total := 0
+	for _, job := range jobs_list {
+		started, _ := job.Started()
+		ls := scrapinghub.LinesStream{Conn: conn, Workers: flags.Workers}
+		ch_lines, errch := ls.ItemsAsJsonLines(job.Id)
+		merged := 0
+		for line := range ch_lines {
+			item, err := decode_item(line)
+			if err != nil {
+				log.Fatalf("items-merge error: job %s: can't decode item: %s\n", job.Id, err)
+			}
+			item, ok := filter.Apply(item)
+			if !ok {
+				continue
+			}
+			item["_job_id"] = job.Id
+			item["_job_started"] = started.Format(time.RFC3339)
+			if err := w.Write(job.Id, item); err != nil {
+				log.Fatalf("items-merge error: %s\n", err)
+			}
+			merged++
+		}
+		for err := range errch {
+			log.Fatalf("items-merge error: %s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "Merged %d items of job %s\n", merged, job.Id)
+		total += merged
+	}
+	if err := w.Close(); err != nil {
+		log.Fatalf("items-merge error: %s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "Merged %d items of %d jobs\n", total, len(jobs_list))
+}
+
+// Print a sample of -n items of the job (random with -random, or else spread
+// over the job) retrieving only those items
+func cmd_items_sample(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <job_id>\n")
+	}
+	job_id := args[0]
+	n := flags.N
+	if n <= 0 {
+		n = 50
+	}
+	sample, err := scrapinghub.SampleItems(conn, job_id, n, flags.Random, flags.Workers)
+	if err != nil {
+		log.Fatalf("items-sample error: %s\n", err)
+	}
+	for _, r := range sample {
+		if flags.AsJsonLines {
+			line, err := json.Marshal(r.Item)
+			if err != nil {
+				log.Fatalf("items-sample error: %s\n", err)
+			}
+			print_out(flags, "%s", line)
+		} else {
+			print_item_table(flags, r.Offset, r.Item)
+		}
+	}
+}
+
+// Statistics of the numeric fields of the items of the job (all of them, or
+// a sample of -n items), with their percentiles and histograms
+func cmd_items_stats(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <job_id>\n")
+	}
+	job_id := args[0]
+	stats := scrapinghub.NewItemStats()
+	if flags.N > 0 {
+		sample, err := scrapinghub.SampleItems(conn, job_id, flags.N, flags.Random, flags.Workers)
+		if err != nil {
+			log.Fatalf("items-stats error: %s\n", err)
+		}
+		for _, r := range sample {
+			stats.Add(r.Item)
+		}
+	} else {
+		ls := scrapinghub.LinesStream{Conn: conn, Count: flags.Count, Offset: flags.Offset,
+			Workers: flags.Workers, Unordered: flags.Unordered}
+		ch_lines, errch := ls.ItemsAsJsonLines(job_id)
+		for line := range ch_lines {
+			item, err := decode_item(line)
+			if err != nil {
+				log.Fatalf("items-stats error: can't decode item: %s\n", err)
+			}
+			stats.Add(item)
+		}
+		for err := range errch {
+			log.Fatalf("items-stats error: %s\n", err)
+		}
+	}
+
+	num := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', 6, 64)
+	}
+	what := "items"
+	if flags.N > 0 {
+		what = "items sampled"
+	}
+	summary := table{
+		Title:   fmt.Sprintf("Job %s: %d %s", job_id, stats.Items, what),
+		Headers: []string{"field", "count", "min", "p50", "p90", "p99", "max", "mean", "stddev"},
+	}
+	tables := []table{summary}
+	var data []map[string]interface{}
+	for _, f := range stats.Fields() {
+		tables[0].Rows = append(tables[0].Rows, []string{f.Path, strconv.Itoa(f.Count), num(f.Min),
+			num(f.Percentile(50)), num(f.Percentile(90)), num(f.Percentile(99)), num(f.Max), num(f.Mean), num(f.StdDev)})
+		hist := f.Histogram(flags.Bins)
+		h := table{Title: "Histogram of " + f.Path, Headers: []string{"from", "to", "count", ""}}
+		for _, bin := range hist {
+			bar := 0
+			if f.Count > 0 {
+				bar = int(math.Round(40 * float64(bin.Count) / float64(f.Count)))
 			}
-			print_out(flags, dashes(140))
+			h.Rows = append(h.Rows, []string{num(bin.From), num(bin.To), strconv.Itoa(bin.Count), strings.Repeat("#", bar)})
 		}
+		tables = append(tables, h)
+		data = append(data, map[string]interface{}{
+			"path": f.Path, "count": f.Count, "min": f.Min, "max": f.Max, "mean": f.Mean, "stddev": f.StdDev,
+			"p50": f.Percentile(50), "p90": f.Percentile(90), "p99": f.Percentile(99), "histogram": hist,
+		})
 	}
+	render_tables(flags, tables, map[string]interface{}{"job_id": job_id, "items": stats.Items,
+		"sampled": flags.N > 0, "fields": data})
 }
 
 func cmd_as_project_slybot(conn *scrapinghub.Connection, args []string, flags *PFlags) {
@@ -389,15 +1178,46 @@ func cmd_log(conn *scrapinghub.Connection, args []string, flags *PFlags) {
 	job_id := args[0]
 	count := flags.Count
 	offset := flags.Offset
+	if flags.Format != "table" && flags.Format != "json" {
+		log.Fatalf("log error: -format %s not available, use -format json for the records as JsonLines\n", flags.Format)
+	}
+	var since time.Time
+	if flags.Since != "" {
+		period, err := parse_duration(flags.Since)
+		if err != nil || period <= 0 {
+			log.Fatalf("log error: wrong -since value: %s\n", flags.Since)
+		}
+		since = time.Now().Add(-period)
+	}
+	filter, err := scrapinghub.NewLogFilter(flags.Level, flags.Logger, flags.Grep, since)
+	if err != nil {
+		log.Fatalf("log error: %s\n", err)
+	}
+	if err := expand_output(conn, flags, "", "", job_id); err != nil {
+		log.Fatalf("log error: %s\n", err)
+	}
 
 	if flags.Tailing {
-		log_tailing(conn, job_id)
+		log_tailing(conn, job_id, filter, flags)
+	} else if flags.Format == "json" || !filter.Empty() {
+		ls := scrapinghub.LinesStream{Conn: conn, Count: count, Offset: offset,
+			Workers: flags.Workers, Unordered: flags.Unordered}
+		ch_records, ch_err := ls.LogRecords(job_id)
+
+		lc := &scrapinghub.LogContext{Filter: filter, Context: flags.Context}
+		for rec := range ch_records {
+			print_log_records(flags, lc, rec)
+		}
+		for err := range ch_err {
+			log.Fatalf("log error: %s\n", err)
+		}
 	} else {
-		ls := scrapinghub.LinesStream{Conn: conn, Count: count, Offset: offset}
+		ls := scrapinghub.LinesStream{Conn: conn, Count: count, Offset: offset,
+			Workers: flags.Workers, Unordered: flags.Unordered}
 		ch_lines, ch_err := ls.LogLines(job_id)
 
 		for line := range ch_lines {
-			print_out(flags, line)
+			print_out(flags, "%s", line)
 		}
 		for err := range ch_err {
 			log.Fatalf("log error: %s\n", err)
@@ -405,7 +1225,31 @@ func cmd_log(conn *scrapinghub.Connection, args []string, flags *PFlags) {
 	}
 }
 
-func log_tailing(conn *scrapinghub.Connection, job_id string) {
+// Print the records selected by the filter of `lc` because of `rec`, as
+// lines of JSON with -format json or else as their lines separated by "--"
+// from the previous ones when there are records not printed between them
+func print_log_records(flags *PFlags, lc *scrapinghub.LogContext, rec *scrapinghub.LogRecord) {
+	records, gap := lc.Feed(rec)
+	if gap && flags.Format != "json" {
+		print_out(flags, "--")
+	}
+	for _, r := range records {
+		if flags.Format != "json" {
+			print_out(flags, "%s", r.Raw)
+			continue
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			log.Fatalf("log error: %s\n", err)
+		}
+		print_out(flags, "%s", line)
+	}
+}
+
+// Print the new lines of the log of the job every second. With -format json
+// or a filter the lines are grouped in records, a record is printed when the
+// next one starts or when no more lines are added to the log in a second.
+func log_tailing(conn *scrapinghub.Connection, job_id string, filter *scrapinghub.LogFilter, flags *PFlags) {
 	var jobs scrapinghub.Jobs
 	jobinfo, err := jobs.JobInfo(conn, job_id)
 	if err != nil {
@@ -418,18 +1262,40 @@ func log_tailing(conn *scrapinghub.Connection, job_id string) {
 	}
 	count := 10 // ask for this lines in every call
 	ls := scrapinghub.LinesStream{Conn: conn, Count: count, Offset: offset}
+	var parser scrapinghub.LogParser
+	records := flags.Format == "json" || !filter.Empty()
+	lc := &scrapinghub.LogContext{Filter: filter, Context: flags.Context}
+	// Tailing ends with Ctrl-C, the output (-o) is completed then
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
 	for {
 		retrieved := 0
 		ch_lines, ch_err := ls.LogLines(job_id)
 		for line := range ch_lines {
 			retrieved++
-			fmt.Fprintf(os.Stdout, "%s\n", line)
+			if !records {
+				print_out(flags, "%s", line)
+			} else if rec := parser.Feed(line); rec != nil {
+				print_log_records(flags, lc, rec)
+			}
 		}
 		for err := range ch_err {
 			log.Fatalf("%s\n", err)
 		}
+		if retrieved == 0 {
+			if rec := parser.Flush(); rec != nil {
+				print_log_records(flags, lc, rec)
+			}
+		}
 		ls.Offset += retrieved
-		time.Sleep(time.Second)
+		select {
+		case <-interrupted:
+			if rec := parser.Flush(); rec != nil {
+				print_log_records(flags, lc, rec)
+			}
+			return
+		case <-time.After(time.Second):
+		}
 	}
 }
 
@@ -448,6 +1314,368 @@ func cmd_reschedule(conn *scrapinghub.Connection, args []string, flags *PFlags)
 	}
 }
 
+func cmd_retry_failed(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <project_id>\n")
+	}
+	project_id := args[0]
+	options := equality_list_to_map(args[1:])
+
+	policy := scrapinghub.RetryPolicy{Count: flags.Count}
+	if reasons, ok := options["reasons"]; ok {
+		policy.CloseReasons = strings.Split(reasons, ",")
+	}
+	var err error
+	if v, ok := options["max_errors"]; ok {
+		if policy.MaxErrors, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("retry-failed error: wrong max_errors value: %s\n", v)
+		}
+	}
+	policy.MaxAttempts = 3
+	if v, ok := options["max_attempts"]; ok {
+		if policy.MaxAttempts, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("retry-failed error: wrong max_attempts value: %s\n", v)
+		}
+	}
+
+	var jobs scrapinghub.Jobs
+	results, err := jobs.RetryFailed(conn, project_id, &policy)
+	if err != nil {
+		log.Fatalf("retry-failed error: %s\n", err)
+	}
+	outfmt := "| %10s | %25s | %20s | %7s | %30s |\n"
+	print_out(flags, outfmt, "id", "spider", "close_reason", "attempt", "result")
+	print_out(flags, "%s", dashes(108))
+	tag_errors := 0
+	for _, r := range results {
+		result := "new job " + r.NewJobId
+		if r.Err != nil {
+			result = fmt.Sprintf("error: %s", r.Err)
+		} else if r.Skipped != "" {
+			result = "skipped: " + r.Skipped
+		} else if r.TagErr != nil {
+			result += fmt.Sprintf(", not tagged %s: %s", scrapinghub.RETRIED_TAG, r.TagErr)
+			tag_errors++
+		}
+		print_out(flags, "| %10s | %25s | %20s | %7d | %30s |\n", r.Job.Id, r.Job.Spider, r.Job.CloseReason, r.Attempt, result)
+	}
+	if tag_errors > 0 {
+		fmt.Fprintf(os.Stderr, "%d failed jobs were retried but not tagged %s, tag them before the next run\n", tag_errors, scrapinghub.RETRIED_TAG)
+	}
+}
+
+func cmd_watch(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <project_id>\n")
+	}
+	if err := scrapinghub.ValidateProjectID(args[0]); err != nil {
+		log.Fatalf("watch error: %s\n", err)
+	}
+	watcher := scrapinghub.Watcher{Conn: conn, ProjectID: args[0], Interval: flags.Interval,
+		Count: flags.Count, Filters: equality_list_to_map(args[1:])}
+
+	ch_events, errch := watcher.Watch(nil)
+	for {
+		select {
+		case ev, ok := <-ch_events:
+			if !ok {
+				return
+			}
+			line, err := json.Marshal(ev)
+			if err != nil {
+				log.Fatalf("watch error: %s\n", err)
+			}
+			print_out(flags, "%s", line)
+		case err := <-errch:
+			if err != nil {
+				log.Printf("watch error: %s\n", err)
+			}
+		}
+	}
+}
+
+func cmd_notify(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if flags.Project == "" {
+		log.Fatalf("Missing option: -project\n")
+	}
+	if err := scrapinghub.ValidateProjectID(flags.Project); err != nil {
+		log.Fatalf("notify error: %s\n", err)
+	}
+	if flags.Sinks == "" {
+		log.Fatalf("Missing option: -sinks\n")
+	}
+	notifiers, err := scrapinghub.LoadNotifiers(flags.Sinks)
+	if err != nil {
+		log.Fatalf("notify error: %s\n", err)
+	}
+	dispatcher := scrapinghub.NotifyDispatcher{Notifiers: notifiers}
+	for _, t := range strings.Split(flags.NotifyOn, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			event_type, err := scrapinghub.ParseJobEventType(t)
+			if err != nil {
+				log.Fatalf("notify error: %s\n", err)
+			}
+			dispatcher.On = append(dispatcher.On, event_type)
+		}
+	}
+
+	watcher := scrapinghub.Watcher{Conn: conn, ProjectID: flags.Project, Interval: flags.Interval,
+		Count: flags.Count, Filters: equality_list_to_map(args)}
+	ch_events, errch := watcher.Watch(nil)
+	for {
+		select {
+		case ev, ok := <-ch_events:
+			if !ok {
+				return
+			}
+			sent, errs := dispatcher.Dispatch(&ev)
+			if sent {
+				fmt.Printf("%s notified job %s %s\n", ev.Time.Format("2006-01-02 15:04:05"), ev.Job.Id, ev.Type)
+			}
+			for _, err := range errs {
+				log.Printf("notify error: %s\n", err)
+			}
+		case err := <-errch:
+			if err != nil {
+				log.Printf("notify error: %s\n", err)
+			}
+		}
+	}
+}
+
+func cmd_exporter(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	projects := args
+	if flags.Project != "" {
+		projects = append(strings.Split(flags.Project, ","), projects...)
+	}
+	if len(projects) == 0 {
+		log.Fatalf("Missing option: -project\n")
+	}
+	for _, project_id := range projects {
+		if err := scrapinghub.ValidateProjectID(project_id); err != nil {
+			log.Fatalf("exporter error: %s\n", err)
+		}
+	}
+
+	collector := scrapinghub.MetricsCollector{Conn: conn, Projects: projects, Count: flags.Count}
+	go func() {
+		for {
+			if err := collector.Collect(); err != nil {
+				log.Printf("exporter error: %s\n", err)
+			}
+			time.Sleep(flags.Interval)
+		}
+	}()
+	http.Handle("/metrics", &collector)
+	fmt.Printf("Exporting metrics of projects %s on http://%s/metrics\n", strings.Join(projects, ", "), flags.Listen)
+	log.Fatal(http.ListenAndServe(flags.Listen, nil))
+}
+
+// Period of the commands report and items-merge when -since is not given
+const default_since = "7d"
+
+// Parse a duration like time.ParseDuration, also accepting days (d) and
+// weeks (w) as units, e.g: 7d, 2w
+func parse_duration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1:]]; ok {
+			n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %s", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+func cmd_report(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <project_id>\n")
+	}
+	project_id := args[0]
+	if flags.Since == "" {
+		flags.Since = default_since
+	}
+	period, err := parse_duration(flags.Since)
+	if err != nil || period <= 0 {
+		log.Fatalf("report error: wrong -since value: %s\n", flags.Since)
+	}
+
+	until := time.Now()
+	var jobs scrapinghub.Jobs
+	jobs_list, err := jobs.ListSince(conn, project_id, until.Add(-2*period), nil)
+	if err != nil {
+		log.Fatalf("report error: %s\n", err)
+	}
+	var spiders scrapinghub.Spiders
+	spider_list, err := spiders.List(conn, project_id)
+	if err != nil {
+		log.Fatalf("report error: %s\n", err)
+	}
+	var names []string
+	for _, spider := range spider_list.Spiders {
+		names = append(names, spider["id"])
+	}
+
+	report := scrapinghub.BuildReport(project_id, jobs_list, names, until, period, 50)
+
+	summary := table{
+		Title: fmt.Sprintf("Project %s: %s - %s (previous period in brackets)", project_id,
+			report.Since.Format("2006-01-02 15:04"), report.Until.Format("2006-01-02 15:04")),
+		Headers: []string{"spider", "runs", "success %", "median time", "p95 time", "items/run", "errors/run", "last run"},
+	}
+	var problems table
+	problems.Title = "Problems"
+	problems.Headers = []string{"spider", "problem"}
+	for _, sr := range report.Spiders {
+		cur, prev := &sr.Current, &sr.Previous
+		last_run := "never"
+		if !sr.LastRun.IsZero() {
+			last_run = sr.LastRun.Format("2006-01-02 15:04")
+		}
+		summary.Rows = append(summary.Rows, []string{
+			sr.Spider,
+			fmt.Sprintf("%d (%d)", cur.Runs, prev.Runs),
+			fmt.Sprintf("%.1f (%.1f)", cur.SuccessRate, prev.SuccessRate),
+			fmt.Sprintf("%s (%s)", seconds_str(cur.MedianDuration), seconds_str(prev.MedianDuration)),
+			fmt.Sprintf("%s (%s)", seconds_str(cur.P95Duration), seconds_str(prev.P95Duration)),
+			fmt.Sprintf("%.1f (%.1f)", cur.ItemsPerRun, prev.ItemsPerRun),
+			fmt.Sprintf("%.1f (%.1f)", cur.ErrorsPerRun, prev.ErrorsPerRun),
+			last_run,
+		})
+		if sr.NotRun {
+			problems.Rows = append(problems.Rows, []string{sr.Spider, "no runs in the period"})
+		}
+		if sr.ItemsDropped {
+			problems.Rows = append(problems.Rows, []string{sr.Spider, fmt.Sprintf("items per run dropped %.1f%%", -sr.ItemsTrend)})
+		}
+		// The reasons with more jobs first, by name if the same number
+		reasons := sorted_keys(cur.CloseReasons)
+		sort.SliceStable(reasons, func(i, j int) bool {
+			return cur.CloseReasons[reasons[i]] > cur.CloseReasons[reasons[j]]
+		})
+		for _, reason := range reasons {
+			if reason != "finished" {
+				problems.Rows = append(problems.Rows, []string{sr.Spider, fmt.Sprintf("%d jobs closed with reason %s", cur.CloseReasons[reason], reason)})
+			}
+		}
+	}
+	tables := []table{summary}
+	if len(problems.Rows) > 0 {
+		tables = append(tables, problems)
+	}
+	render_tables(flags, tables, report)
+}
+
+func seconds_str(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func cmd_scheduler(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <schedule_file>\n")
+	}
+	schedule_file := args[0]
+	entries, err := scrapinghub.LoadSchedule(schedule_file)
+	if err != nil {
+		log.Fatalf("scheduler error: %s\n", err)
+	}
+
+	scheduler := scrapinghub.Scheduler{Conn: conn, Entries: entries, StatePath: schedule_file + ".state"}
+	fmt.Printf("Scheduler started with %d jobs, state file: %s\n", len(entries), scheduler.StatePath)
+	ch_runs, errch := scheduler.Run(nil)
+	for run := range ch_runs {
+		when := run.Time.Format("2006-01-02 15:04:05")
+		if run.Err != nil {
+			fmt.Printf("%s %s: error scheduling spider %s: %s\n", when, run.Entry.Name, run.Entry.Spider, run.Err)
+		} else if run.Skipped {
+			fmt.Printf("%s %s: skipped, spider %s is already running\n", when, run.Entry.Name, run.Entry.Spider)
+		} else {
+			fmt.Printf("%s %s: scheduled job %s\n", when, run.Entry.Name, run.JobId)
+		}
+	}
+	for err := range errch {
+		log.Fatalf("scheduler error: %s\n", err)
+	}
+}
+
+func cmd_schedule_plan(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 1 {
+		log.Fatalf("Missing argument: <schedule_file>\n")
+	}
+	entries, err := scrapinghub.LoadSchedule(args[0])
+	if err != nil {
+		log.Fatalf("schedule-plan error: %s\n", err)
+	}
+	count := flags.Count
+	if count <= 0 {
+		count = 5
+	}
+
+	scheduler := scrapinghub.Scheduler{Conn: conn, Entries: entries}
+	outfmt := "| %20s | %25s | %10s | %25s |\n"
+	print_out(flags, outfmt, "time", "name", "project", "spider")
+	print_out(flags, "%s", dashes(93))
+	for _, run := range scheduler.Plan(time.Now(), count) {
+		print_out(flags, outfmt, run.Time.Format("2006-01-02 15:04"), run.Entry.Name, run.Entry.Project, run.Entry.Spider)
+	}
+}
+
+func cmd_workflow(conn *scrapinghub.Connection, args []string, flags *PFlags) {
+	if len(args) < 2 {
+		log.Fatalf("Missing arguments: <run|status> and <workflow_file>\n")
+	}
+	action := args[0]
+	workflow_file := args[1]
+	wf, err := scrapinghub.LoadWorkflow(workflow_file)
+	if err != nil {
+		log.Fatalf("workflow error: %s\n", err)
+	}
+	runner := scrapinghub.WorkflowRunner{Conn: conn, Workflow: wf,
+		StatePath: workflow_file + ".state", Interval: flags.Interval}
+
+	switch action {
+	case "run":
+		ch_events, errch := runner.Run()
+		for ev := range ch_events {
+			line := fmt.Sprintf("%s %s", ev.Time.Format("2006-01-02 15:04:05"), ev.Step)
+			if ev.Item != "" {
+				line += fmt.Sprintf(" [%s]", ev.Item)
+			}
+			if ev.JobId != "" {
+				line += fmt.Sprintf(" job %s", ev.JobId)
+			}
+			line += ": " + ev.Status
+			if ev.Err != nil {
+				line += fmt.Sprintf(": %s", ev.Err)
+			}
+			fmt.Println(line)
+		}
+		for err := range errch {
+			log.Fatalf("workflow error: %s\n", err)
+		}
+		fmt.Printf("Workflow %s completed\n", wf.Name)
+	case "status":
+		if err := runner.LoadState(); err != nil {
+			log.Fatalf("workflow error: %s\n", err)
+		}
+		outfmt := "| %25s | %10s | %20s | %12s | %8s |\n"
+		print_out(flags, outfmt, "step", "status", "item", "job", "attempts")
+		print_out(flags, "%s", dashes(92))
+		for _, step := range wf.Steps {
+			st := runner.State[step.Name]
+			print_out(flags, outfmt, step.Name, st.Status, "", "", "")
+			for _, job := range st.Jobs {
+				print_out(flags, outfmt, "", job.Status, job.Item, job.JobId, fmt.Sprintf("%d", job.Attempts))
+			}
+		}
+	default:
+		log.Fatalf("workflow error: unknown action '%s', use run or status\n", action)
+	}
+}
+
 func cmd_eggs_add(conn *scrapinghub.Connection, args []string, flags *PFlags) {
 	if len(args) < 2 {
 		log.Fatalf("Missing arguments: <project_id> and <egg_path>\n")
@@ -646,11 +1874,50 @@ func main() {
 	fcsv_fields := flag.String("fields", "", "When -csv given, list of comma separated fields to include in the CSV")
 	tail := flag.Bool("tail", false, "The same that `tail -f` for command `log`")
 	debug := flag.Bool("debug", false, "debug mode for some commands (deploy: not remove debug dir)")
+	key := flag.String("key", "", "For command job-diff, field used to match the items of both jobs; for command items-dedup, comma separated fields of the key of the items (their content if not given)")
+	interval := flag.Duration("interval", 30*time.Second, "Polling interval for the commands waiting on jobs or polling the API (workflow, watch, notify, exporter)")
+	notify_on := flag.String("on", "failed", "For command notify, comma separated list of job events to notify (scheduled, started, finished, failed, counters_changed)")
+	project := flag.String("project", "", "Project id for the commands not taking it as argument (notify, exporter: comma separated list)")
+	listen := flag.String("listen", ":9101", "For command exporter, address to serve the metrics")
+	since := flag.String("since", "", "For command report, length of the period of the report (e.g: 12h, 7d, 2w, default 7d); for command items-merge, period of the jobs to merge (default 7d); for command log, print only the records of this last period (e.g: 10m)")
+	level := flag.String("level", "", "For command log, print only the records of this level or higher: DEBUG, INFO, WARNING, ERROR or CRITICAL")
+	logger := flag.String("logger", "", "For command log, print only the records of this logger or its children (e.g: scrapy.core.scraper)")
+	grep := flag.String("grep", "", "For command log, print only the records matching this regular expression (e.g: 'Timeout|DNS')")
+	context_lines := flag.Int("C", 0, "For command log with a filter, number of records printed before and after every record selected, like grep -C")
+	format := flag.String("format", "table", "Output format for command report: table, json, markdown or html; for command items: csv, tsv or parquet (requires -o); for command items-schema: table, json, markdown, html or jsonschema; for commands validate and items-stats: table, json, markdown or html; for command log: json for the records of the log as JsonLines")
+	compress := flag.String("compress", "", "Compression of the output file (-o): gzip, zstd or none (default: by the extension, .gz or .zst); for command items with -format parquet, compression codec: snappy (default), zstd, gzip or none. Requires -o")
+	resume := flag.Bool("resume", false, "For command items with -jl and -o, resume the download from the checkpoint saved in <output>.checkpoint")
+	workers := flag.Int("workers", 1, "For commands items and log, number of batches of lines retrieved concurrently")
+	unordered := flag.Bool("unordered", false, "When -workers > 1, write the batches of lines as they are retrieved instead of in order")
+	to := flag.String("to", "", "For command items, export the items to a destination instead of the output, e.g: sqlite://out.db?table=items")
+	separator := flag.String("sep", ".", "For command items with -format csv or tsv, separator of the keys of the nested fields (e.g: price.amount)")
+	lists := flag.String("lists", "join", "For command items with -format csv or tsv, join the values of the lists or explode them in a row per value (the product of the values if there are several lists)")
+	list_separator := flag.String("list_sep", "|", "For command items with -format csv or tsv and -lists join, separator of the values of the lists")
+	delimiter := flag.String("delimiter", "", "For command items with -format csv or tsv, delimiter of the fields (default: comma for csv, tab for tsv)")
+	quote := flag.String("quote", "minimal", "For command items with -format csv or tsv, quote the fields: minimal (only when needed), all or none")
+	where := flag.String("where", "", "For command items, keep only the items matching the expression, e.g: 'price > 10 && category == \"books\"'")
+	select_fields := flag.String("select", "", "For command items, keep only these fields of the items (e.g: -select=name,price.amount,url)")
+	schema := flag.String("schema", "", "For command validate, file with the JSON Schema of the items")
+	invalid := flag.String("invalid", "", "For command validate, file to write the items not valid as JsonLines, with their offsets and violations")
+	duplicates := flag.String("duplicates", "", "For command items-dedup, file to write the duplicated items found as JsonLines (job id, offset and key)")
+	spider := flag.String("spider", "", "For command items-merge, spider of the jobs to merge")
+	s3_endpoint := flag.String("s3_endpoint", "", "Endpoint of the S3 compatible storage of the outputs s3://<bucket>/<key> (default: $S3_ENDPOINT or s3.amazonaws.com), e.g: http://localhost:9000")
+	sample_size := flag.Int("n", 0, "For command items-sample, number of items of the sample (default 50); for command items-stats, use a sample of n items instead of all the items")
+	random := flag.Bool("random", false, "For commands items-sample and items-stats with -n, take the items at random offsets instead of spread evenly over the job")
+	bins := flag.Int("bins", 10, "For command items-stats, number of ranges of the histograms")
+	sinks := flag.String("sinks", "", "For command notify, file with the notification sinks (webhooks, commands, emails)")
 
 	flag.Usage = cmd_help
 
 	flag.Parse()
 
+	var cmd string
+	var args []string
+	if len(flag.Args()) > 0 {
+		cmd = flag.Arg(0)
+		args = parse_cmd_flags(flag.Args()[1:])
+	}
+
 	// Set flags
 	gflags.Count = *count
 	gflags.Offset = *offset
@@ -662,6 +1929,41 @@ func main() {
 	gflags.CSVFlags.Fields = *fcsv_fields
 	gflags.Tailing = *tail
 	gflags.Debug = *debug
+	gflags.Key = *key
+	gflags.Interval = *interval
+	gflags.NotifyOn = *notify_on
+	gflags.Project = *project
+	gflags.Sinks = *sinks
+	gflags.Listen = *listen
+	gflags.Since = *since
+	gflags.Level = *level
+	gflags.Logger = *logger
+	gflags.Grep = *grep
+	gflags.Context = *context_lines
+	gflags.Format = *format
+	gflags.Resume = *resume
+	gflags.Workers = *workers
+	gflags.Unordered = *unordered
+	gflags.To = *to
+	gflags.Compress = *compress
+	gflags.Separator = *separator
+	gflags.Lists = *lists
+	gflags.ListSeparator = *list_separator
+	gflags.Delimiter = *delimiter
+	gflags.Quote = *quote
+	gflags.Where = *where
+	gflags.Select = *select_fields
+	gflags.Schema = *schema
+	gflags.Invalid = *invalid
+	gflags.Duplicates = *duplicates
+	gflags.Spider = *spider
+	gflags.S3Endpoint = *s3_endpoint
+	gflags.N = *sample_size
+	gflags.Random = *random
+	gflags.Bins = *bins
+	if gflags.Compress != "" && gflags.Output == "" {
+		log.Fatalf("-compress requires an output file (-o), the standard output is not compressed\n")
+	}
 
 	commands := map[string]CmdFun{
 		"spiders":             cmd_spiders,
@@ -671,10 +1973,25 @@ func main() {
 		"stop":                cmd_jobs_stop,
 		"update":              cmd_jobs_update,
 		"delete":              cmd_jobs_delete,
+		"job-diff":            cmd_job_diff,
 		"items":               cmd_items,
+		"items-schema":        cmd_items_schema,
+		"validate":            cmd_validate,
+		"items-dedup":         cmd_items_dedup,
+		"items-merge":         cmd_items_merge,
+		"items-sample":        cmd_items_sample,
+		"items-stats":         cmd_items_stats,
 		"project-slybot":      cmd_as_project_slybot,
 		"log":                 cmd_log,
 		"reschedule":          cmd_reschedule,
+		"retry-failed":        cmd_retry_failed,
+		"watch":               cmd_watch,
+		"notify":              cmd_notify,
+		"exporter":            cmd_exporter,
+		"report":              cmd_report,
+		"scheduler":           cmd_scheduler,
+		"schedule-plan":       cmd_schedule_plan,
+		"workflow":            cmd_workflow,
 		"eggs-add":            cmd_eggs_add,
 		"eggs-list":           cmd_eggs_list,
 		"eggs-delete":         cmd_eggs_delete,
@@ -683,7 +2000,7 @@ func main() {
 		"build-egg":           cmd_deploy_build_egg,
 	}
 
-	if len(flag.Args()) <= 0 {
+	if cmd == "" {
 		fmt.Fprintf(os.Stderr, "Usage: shubc [options] url\n")
 	} else {
 		// Create new connection
@@ -697,8 +2014,6 @@ func main() {
 			log.Fatalf("error setting api url: %s", err)
 		}
 
-		cmd := flag.Arg(0)
-		args := flag.Args()[1:]
 		if cmd == "help" {
 			cmd_help()
 		} else {
@@ -708,6 +2023,9 @@ func main() {
 					os.Exit(1)
 				}
 				cmd_func(&conn, args, &gflags)
+				if err := close_output(&gflags); err != nil {
+					log.Fatalf("Error writing output: %s\n", err)
+				}
 			} else {
 				log.Fatalf("'%s' command not found\n", cmd)
 			}
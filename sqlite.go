@@ -0,0 +1,384 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Write the items to a table of a SQLite database. The columns of the table
+// are inferred from the first `sample` items (nested values are stored as
+// JSON) and added when new fields appear. Every row has the column job_id
+// and the rows of a job are replaced if it's exported again. The column names
+// are case insensitive in SQLite, so a field whose name is used by another
+// column (e.g: "Price" and "price", or "job_id") is stored in a column with
+// a suffix (e.g: "price_2") or, for job_id, "_job_id".
+type sqlite_writer struct {
+	db     *sql.DB
+	table  string
+	sample int
+	batch  int
+	buffer []job_item
+	// Columns of the table in the order of the inserts and the field stored
+	// in every one ("" if none)
+	columns []string
+	fields  []string
+	// Index of the column of every field and of every column name lowercased
+	column_of map[string]int
+	lower     map[string]int
+	jobs      map[string]bool
+	tx        *sql.Tx
+	stmt      *sql.Stmt
+	in_tx     int
+	ready     bool
+}
+
+func new_sqlite_writer(u *url.URL) (*sqlite_writer, error) {
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, fmt.Errorf("sqlite: missing database file, e.g: sqlite://out.db?table=items")
+	}
+	q := u.Query()
+	sw := &sqlite_writer{table: q.Get("table"), sample: 100, batch: 1000,
+		column_of: make(map[string]int), lower: make(map[string]int), jobs: make(map[string]bool)}
+	if sw.table == "" {
+		sw.table = "items"
+	}
+	for name, value := range map[string]*int{"sample": &sw.sample, "batch": &sw.batch} {
+		if q.Get(name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(q.Get(name))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("sqlite: wrong value for %s: %s", name, q.Get(name))
+		}
+		*value = n
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %s", err)
+	}
+	sw.db = db
+	return sw, nil
+}
+
+func quote_ident(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Returns the SQLite type of a value decoded from JSON, "" for null
+func sqlite_type(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return "INTEGER"
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			return "INTEGER"
+		}
+		return "REAL"
+	case float64:
+		return "REAL"
+	case string:
+		return "TEXT"
+	}
+	// Objects and lists are stored as JSON
+	return "TEXT"
+}
+
+// Returns the value to store in the database for a value decoded from JSON
+func sqlite_value(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case nil, string, float64:
+		return val, nil
+	case bool:
+		if val {
+			return 1, nil
+		}
+		return 0, nil
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return n, nil
+		}
+		return val.Float64()
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// Returns the columns of the table, none if it doesn't exist
+func (sw *sqlite_writer) tableColumns() ([]string, error) {
+	rows, err := sw.db.Query("PRAGMA table_info(" + quote_ident(sw.table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// Create the table, or add the columns missing in it, with the columns and
+// types inferred from the items buffered
+func (sw *sqlite_writer) init() error {
+	types := make(map[string]string)
+	var names []string
+	for _, row := range sw.buffer {
+		for k, v := range row.item {
+			t := sqlite_type(v)
+			prev, seen := types[k]
+			if !seen {
+				names = append(names, k)
+			}
+			switch {
+			case prev == "" || prev == t:
+				types[k] = t
+			case t == "":
+			case prev == "INTEGER" && t == "REAL", prev == "REAL" && t == "INTEGER":
+				types[k] = "REAL"
+			default:
+				types[k] = "TEXT"
+			}
+		}
+	}
+	sort.Strings(names)
+
+	existing, err := sw.tableColumns()
+	if err != nil {
+		return err
+	}
+	for _, name := range existing {
+		sw.lower[strings.ToLower(name)] = len(sw.columns)
+		sw.columns = append(sw.columns, name)
+		sw.fields = append(sw.fields, "")
+	}
+	if len(existing) == 0 {
+		sw.assignColumn("")
+		defs := []string{quote_ident("job_id") + " TEXT"}
+		for _, name := range names {
+			column, _ := sw.assignColumn(name)
+			t := types[name]
+			if t == "" {
+				t = "TEXT"
+			}
+			defs = append(defs, quote_ident(column)+" "+t)
+		}
+		create := fmt.Sprintf("CREATE TABLE %s (%s)", quote_ident(sw.table), strings.Join(defs, ", "))
+		if _, err := sw.db.Exec(create); err != nil {
+			return err
+		}
+		sw.ready = true
+		return nil
+	}
+
+	// The rows of the jobs exported are replaced by job_id
+	if i, ok := sw.lower["job_id"]; !ok || sw.columns[i] != "job_id" {
+		return fmt.Errorf("table %s exists without a job_id column, export the items to another table", sw.table)
+	}
+	sw.assignColumn("")
+	for _, name := range names {
+		if err := sw.addColumn(name, types[name]); err != nil {
+			return err
+		}
+	}
+	sw.ready = true
+	return nil
+}
+
+// Returns the column of the field `field` ("" for the column job_id, the
+// first one assigned), and true if it's a new column of the table: the
+// column with the name of the field if it's not used by another field, or
+// else the first one free with a suffix _2, _3, ... ("_job_id" first for
+// the fields named job_id)
+func (sw *sqlite_writer) assignColumn(field string) (string, bool) {
+	if i, ok := sw.column_of[field]; ok {
+		return sw.columns[i], false
+	}
+	add := func(name string) (string, bool) {
+		sw.lower[strings.ToLower(name)] = len(sw.columns)
+		sw.column_of[field] = len(sw.columns)
+		sw.columns = append(sw.columns, name)
+		sw.fields = append(sw.fields, field)
+		return name, true
+	}
+	if field == "" {
+		if i, ok := sw.lower["job_id"]; ok {
+			sw.column_of[""] = i
+			return "job_id", false
+		}
+		return add("job_id")
+	}
+	for n := 1; ; n++ {
+		name := field
+		if n == 1 && strings.EqualFold(field, "job_id") {
+			name = "_" + field
+		} else if n > 1 {
+			name = fmt.Sprintf("%s_%d", field, n)
+		}
+		i, used := sw.lower[strings.ToLower(name)]
+		if !used {
+			return add(name)
+		}
+		// A column of the table with this name not used yet by any field
+		if sw.columns[i] == name && sw.fields[i] == "" && i != sw.column_of[""] {
+			sw.fields[i] = field
+			sw.column_of[field] = i
+			return name, false
+		}
+	}
+}
+
+// Add the column of the field to the table if it's new
+func (sw *sqlite_writer) addColumn(field, ctype string) error {
+	name, is_new := sw.assignColumn(field)
+	if !is_new {
+		return nil
+	}
+	if ctype == "" {
+		ctype = "TEXT"
+	}
+	alter := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quote_ident(sw.table), quote_ident(name), ctype)
+	// The table can't be altered in the middle of the transaction
+	if err := sw.commit(); err != nil {
+		return err
+	}
+	_, err := sw.db.Exec(alter)
+	return err
+}
+
+func (sw *sqlite_writer) commit() error {
+	if sw.tx == nil {
+		return nil
+	}
+	sw.stmt.Close()
+	err := sw.tx.Commit()
+	sw.tx = nil
+	sw.stmt = nil
+	sw.in_tx = 0
+	return err
+}
+
+func (sw *sqlite_writer) insert(job_id string, item map[string]interface{}) error {
+	var added []string
+	for k := range item {
+		if _, ok := sw.column_of[k]; !ok {
+			added = append(added, k)
+		}
+	}
+	// Sorted, so the columns of the fields are the same in every export
+	sort.Strings(added)
+	for _, k := range added {
+		if err := sw.addColumn(k, sqlite_type(item[k])); err != nil {
+			return err
+		}
+	}
+	if sw.tx == nil {
+		tx, err := sw.db.Begin()
+		if err != nil {
+			return err
+		}
+		cols := make([]string, len(sw.columns))
+		marks := make([]string, len(sw.columns))
+		for i, c := range sw.columns {
+			cols[i] = quote_ident(c)
+			marks[i] = "?"
+		}
+		stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			quote_ident(sw.table), strings.Join(cols, ", "), strings.Join(marks, ", ")))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		sw.tx = tx
+		sw.stmt = stmt
+	}
+	if !sw.jobs[job_id] {
+		// Replace the rows of the job if it was exported before
+		if _, err := sw.tx.Exec("DELETE FROM "+quote_ident(sw.table)+" WHERE job_id = ?", job_id); err != nil {
+			return err
+		}
+		sw.jobs[job_id] = true
+	}
+
+	values := make([]interface{}, len(sw.columns))
+	job_col := sw.column_of[""]
+	for i, field := range sw.fields {
+		if i == job_col {
+			values[i] = job_id
+			continue
+		}
+		if field == "" {
+			continue
+		}
+		v, err := sqlite_value(item[field])
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	if _, err := sw.stmt.Exec(values...); err != nil {
+		return err
+	}
+	sw.in_tx++
+	if sw.in_tx >= sw.batch {
+		return sw.commit()
+	}
+	return nil
+}
+
+func (sw *sqlite_writer) flushBuffer() error {
+	if !sw.ready {
+		if err := sw.init(); err != nil {
+			return fmt.Errorf("sqlite: %s", err)
+		}
+	}
+	for _, row := range sw.buffer {
+		if err := sw.insert(row.job_id, row.item); err != nil {
+			return fmt.Errorf("sqlite: %s", err)
+		}
+	}
+	sw.buffer = nil
+	return nil
+}
+
+func (sw *sqlite_writer) Write(job_id string, item map[string]interface{}) error {
+	if !sw.ready {
+		sw.buffer = append(sw.buffer, job_item{job_id, item})
+		if len(sw.buffer) < sw.sample {
+			return nil
+		}
+		return sw.flushBuffer()
+	}
+	if err := sw.insert(job_id, item); err != nil {
+		return fmt.Errorf("sqlite: %s", err)
+	}
+	return nil
+}
+
+func (sw *sqlite_writer) Close() error {
+	if len(sw.buffer) > 0 {
+		if err := sw.flushBuffer(); err != nil {
+			sw.db.Close()
+			return err
+		}
+	}
+	if err := sw.commit(); err != nil {
+		sw.db.Close()
+		return fmt.Errorf("sqlite: %s", err)
+	}
+	return sw.db.Close()
+}
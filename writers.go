@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/scrapinghub/shubc/scrapinghub"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Destination of the items of the jobs exported with -to
+type ItemWriter interface {
+	Write(job_id string, item map[string]interface{}) error
+	Close() error
+}
+
+type job_item struct {
+	job_id string
+	item   map[string]interface{}
+}
+
+// Open the writer for the destination given in -to (e.g: sqlite://out.db?table=items),
+// for the output with -format csv, tsv or parquet, or else JsonLines to the output
+func open_item_writer(flags *PFlags) (ItemWriter, error) {
+	if flags.Format == "csv" || flags.Format == "tsv" {
+		return new_csv_writer(flags)
+	}
+	if flags.Format == "parquet" {
+		if flags.Output == "" {
+			return nil, fmt.Errorf("-format parquet requires the output file (-o)")
+		}
+		return new_parquet_writer(flags)
+	}
+	if flags.To == "" {
+		return &jsonlines_writer{flags: flags}, nil
+	}
+	u, err := url.Parse(flags.To)
+	if err != nil {
+		return nil, fmt.Errorf("wrong destination %s: %s", flags.To, err)
+	}
+	switch u.Scheme {
+	case "sqlite":
+		return new_sqlite_writer(u)
+	}
+	return nil, fmt.Errorf("unknown destination %s, use sqlite://<file>?table=<table>", flags.To)
+}
+
+// Decode an item keeping the numbers as json.Number, so integers are not
+// converted to float64
+func decode_item(line string) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewBufferString(line))
+	dec.UseNumber()
+	var item map[string]interface{}
+	if err := dec.Decode(&item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Replace the placeholders {project}, {spider} and {job} of the output (-o)
+// with the ones of the job, e.g: s3://bucket/{project}/{spider}/{job}.jl.gz.
+// The job id in {job} has its slashes replaced by underscores (123_1_2) and
+// the spider is retrieved from the job info if it's not given.
+func expand_output(conn *scrapinghub.Connection, flags *PFlags, project_id, spider, job_id string) error {
+	if !strings.Contains(flags.Output, "{") {
+		return nil
+	}
+	if job_id != "" {
+		project_id = strings.Split(job_id, "/")[0]
+	}
+	if strings.Contains(flags.Output, "{spider}") && spider == "" {
+		if job_id == "" {
+			return fmt.Errorf("the output %s requires the spider", flags.Output)
+		}
+		var jobs scrapinghub.Jobs
+		job, err := jobs.JobInfo(conn, job_id)
+		if err != nil {
+			return err
+		}
+		spider = job.Spider
+	}
+	if strings.Contains(flags.Output, "{project}") && project_id == "" {
+		return fmt.Errorf("the output %s requires jobs of a single project", flags.Output)
+	}
+	if strings.Contains(flags.Output, "{job}") && job_id == "" {
+		return fmt.Errorf("the output %s requires a single job", flags.Output)
+	}
+	flags.Output = strings.NewReplacer("{project}", project_id, "{spider}", spider,
+		"{job}", strings.Replace(job_id, "/", "_", -1)).Replace(flags.Output)
+	return nil
+}
+
+// Write the items as JsonLines to the output (-o or stdout)
+type jsonlines_writer struct {
+	flags *PFlags
+}
+
+func (jw *jsonlines_writer) Write(job_id string, item map[string]interface{}) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	print_out(jw.flags, "%s", line)
+	return nil
+}
+
+func (jw *jsonlines_writer) Close() error {
+	return nil
+}
+
+// A file written through a compressor (gzip or zstd)
+type compressed_file struct {
+	io.WriteCloser
+	file io.WriteCloser
+}
+
+func (cf *compressed_file) Close() error {
+	err := cf.WriteCloser.Close()
+	if cerr := cf.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Returns the compression of the output file `path`: the one given in
+// -compress (gzip, zstd or none), or else the one of its extension (.gz or .zst)
+func output_compression(path, compress string) (string, error) {
+	switch compress {
+	case "gzip", "zstd", "none":
+		return compress, nil
+	case "":
+		switch {
+		case strings.HasSuffix(path, ".gz"):
+			return "gzip", nil
+		case strings.HasSuffix(path, ".zst"):
+			return "zstd", nil
+		}
+		return "none", nil
+	}
+	return "", fmt.Errorf("unknown compression %s, use gzip, zstd or none (snappy only for -format parquet)", compress)
+}
+
+// Open the output file `path`, compressed as given in -compress or by its
+// extension (see output_compression), to append to it or truncating it.
+// Appending to a compressed file adds a new gzip member or zstd frame, which
+// is read as the continuation of the content. A path s3://<bucket>/<key> is
+// uploaded to the object (replacing it, objects can't be appended).
+func open_output_file(flags *PFlags, path, compress string, append bool) (io.WriteCloser, error) {
+	compression, err := output_compression(path, compress)
+	if err != nil {
+		return nil, err
+	}
+	var file io.WriteCloser
+	if strings.HasPrefix(path, "s3://") {
+		file, err = open_s3_output(path, flags.S3Endpoint)
+	} else {
+		mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if append {
+			mode = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		file, err = os.OpenFile(path, mode, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case "gzip":
+		return &compressed_file{WriteCloser: gzip.NewWriter(file), file: file}, nil
+	case "zstd":
+		enc, err := zstd.NewWriter(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &compressed_file{WriteCloser: enc, file: file}, nil
+	}
+	return file, nil
+}